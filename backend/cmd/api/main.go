@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -17,6 +19,9 @@ import (
 )
 
 func main() {
+	migrateCmd := flag.String("migrate", "", `run a migration command and exit: "status" or "up"`)
+	flag.Parse()
+
 	log.Println("Starting RSSY API Server...")
 
 	// Load configuration
@@ -30,27 +35,126 @@ func main() {
 	}
 	defer db.Close()
 
+	if *migrateCmd != "" {
+		if err := runMigrateCommand(db, *migrateCmd); err != nil {
+			log.Fatalf("Migration command failed: %v", err)
+		}
+		return
+	}
+
 	// Initialize schema
 	if err := db.InitSchema(); err != nil {
 		log.Fatalf("Failed to initialize schema: %v", err)
 	}
 
+	// Sanitize posts stored before content was sanitized
+	if _, err := services.SanitizeStoredPosts(db); err != nil {
+		log.Fatalf("Failed to sanitize stored posts: %v", err)
+	}
+
+	// Group posts into stories, starting with those stored before
+	// stories were clustered
+	clusters := services.NewStoryClusterer(db, services.ClusterOptions{
+		Window:       cfg.ClusterWindow,
+		ResolveLinks: cfg.ClusterResolveLinks,
+	})
+	clusters.ClusterStoredPosts()
+
 	// Seed default feeds
 	if err := db.SeedDefaultFeeds(); err != nil {
 		log.Fatalf("Failed to seed default feeds: %v", err)
 	}
 
+	// Create the first administrator on a fresh install
+	if err := bootstrapAdmin(db, cfg); err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+
+	// Create the hub that streams events to clients
+	events := services.NewEventHub()
+
+	// Create feed fetcher shared by the poller and manual refreshes
+	fetcher := services.NewFeedFetcher(db, events, clusters, services.FetcherOptions{
+		Workers:      cfg.FeedFetchWorkers,
+		PerHostLimit: cfg.FeedFetchPerHost,
+		MaxErrors:    cfg.FeedMaxErrors,
+		MaxFeedSize:  cfg.FeedMaxSize,
+		AllowPrivate: cfg.FetchAllowPrivate,
+	})
+
+	// Create the image proxy
+	var media *services.MediaProxy
+	if cfg.MediaProxyEnabled {
+		media, err = services.NewMediaProxy(services.MediaProxyOptions{
+			Secret:       cfg.MediaProxySecret,
+			CacheDir:     cfg.MediaCacheDir,
+			PublicURL:    cfg.MediaPublicURL,
+			MaxImageSize: cfg.MediaMaxImageSize,
+			MaxCacheSize: cfg.MediaCacheMaxSize,
+			Timeout:      cfg.MediaTimeout,
+			AllowPrivate: cfg.MediaAllowPrivate,
+		})
+		if err != nil {
+			log.Fatalf("Failed to start media proxy: %v", err)
+		}
+	} else {
+		log.Println("Media proxy disabled")
+	}
+
+	// Create webhook dispatcher
+	dispatcher := services.NewWebhookDispatcher(db, events, services.WebhookOptions{
+		Interval:     cfg.WebhookInterval,
+		Workers:      cfg.WebhookWorkers,
+		Timeout:      cfg.WebhookTimeout,
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		BackoffBase:  cfg.WebhookBackoffBase,
+		BackoffMax:   cfg.WebhookBackoffMax,
+		AllowPrivate: cfg.WebhookAllowPrivate,
+	})
+
 	// Create handlers
-	h := handlers.New(db)
+	h := handlers.New(db, fetcher, events, media, dispatcher, cfg.SessionTTL)
 
 	// Create router
 	r := router.New(h, cfg.AllowedOrigins)
 
 	// Start feed poller
-	poller := services.NewPoller(db, cfg.FeedRefreshInterval)
+	poller := services.NewPoller(fetcher, services.PollerOptions{
+		Interval:    cfg.FeedRefreshInterval,
+		BackoffBase: cfg.FeedBackoffBase,
+		BackoffMax:  cfg.FeedBackoffMax,
+	})
 	poller.Start()
 	defer poller.Stop()
 
+	// Start post janitor
+	janitor := services.NewJanitor(db, services.JanitorOptions{
+		Interval:       cfg.RetentionInterval,
+		MaxAge:         cfg.RetentionMaxAge,
+		MaxPosts:       cfg.RetentionMaxPosts,
+		DeliveryMaxAge: cfg.WebhookLogMaxAge,
+	})
+	janitor.Start()
+	defer janitor.Stop()
+
+	// Start webhook dispatcher
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	// Start full-text content extractor
+	extractor := services.NewContentExtractor(db, events, clusters, services.ExtractorOptions{
+		Interval:     cfg.ExtractInterval,
+		Workers:      cfg.ExtractWorkers,
+		HostDelay:    cfg.ExtractHostDelay,
+		Timeout:      cfg.ExtractTimeout,
+		MaxAttempts:  cfg.ExtractMaxAttempts,
+		BackoffBase:  cfg.ExtractBackoffBase,
+		BackoffMax:   cfg.ExtractBackoffMax,
+		AllowPrivate: cfg.ExtractAllowPrivate,
+	})
+	extractor.Start()
+	defer extractor.Stop()
+
 	// Create HTTP server
 	srv := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -59,6 +163,8 @@ func main() {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Shutdown waits for open connections, so end the event streams first
+	srv.RegisterOnShutdown(events.Close)
 
 	// Start server in goroutine
 	go func() {
@@ -85,3 +191,58 @@ func main() {
 
 	log.Println("Server stopped")
 }
+
+// bootstrapAdmin creates an administrator from ADMIN_USERNAME and
+// ADMIN_PASSWORD when no accounts exist yet. The first account adopts the
+// feeds and read state of a single-user install.
+func bootstrapAdmin(db *database.DB, cfg *config.Config) error {
+	count, err := db.CountUsers()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if cfg.AdminPassword == "" {
+		log.Println("WARNING: no users exist; set ADMIN_PASSWORD to create an administrator")
+		return nil
+	}
+	if len(cfg.AdminPassword) < services.MinPasswordLength {
+		return fmt.Errorf("ADMIN_PASSWORD must be at least %d characters", services.MinPasswordLength)
+	}
+
+	passwordHash, err := services.HashPassword(cfg.AdminPassword)
+	if err != nil {
+		return err
+	}
+	if _, err := db.CreateUser(cfg.AdminUsername, passwordHash, true); err != nil {
+		return err
+	}
+
+	log.Printf("Created administrator %q", cfg.AdminUsername)
+	return nil
+}
+
+// runMigrateCommand implements the -migrate flag
+func runMigrateCommand(db *database.DB, cmd string) error {
+	switch cmd {
+	case "up":
+		return db.Migrate()
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-40s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q (want \"status\" or \"up\")", cmd)
+	}
+}
@@ -15,6 +15,45 @@ type Config struct {
 	Host                string
 	DatabasePath        string
 	FeedRefreshInterval time.Duration
+	FeedFetchWorkers    int
+	FeedFetchPerHost    int
+	FeedMaxSize         int64
+	FeedMaxErrors       int
+	FeedBackoffBase     time.Duration
+	FeedBackoffMax      time.Duration
+	FetchAllowPrivate   bool
+	RetentionInterval   time.Duration
+	RetentionMaxAge     time.Duration
+	RetentionMaxPosts   int
+	WebhookInterval     time.Duration
+	WebhookWorkers      int
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookBackoffBase  time.Duration
+	WebhookBackoffMax   time.Duration
+	WebhookLogMaxAge    time.Duration
+	WebhookAllowPrivate bool
+	ExtractInterval     time.Duration
+	ExtractWorkers      int
+	ExtractHostDelay    time.Duration
+	ExtractTimeout      time.Duration
+	ExtractMaxAttempts  int
+	ExtractBackoffBase  time.Duration
+	ExtractBackoffMax   time.Duration
+	ExtractAllowPrivate bool
+	MediaProxyEnabled   bool
+	MediaCacheDir       string
+	MediaProxySecret    string
+	MediaPublicURL      string
+	MediaMaxImageSize   int64
+	MediaCacheMaxSize   int64
+	MediaTimeout        time.Duration
+	MediaAllowPrivate   bool
+	ClusterWindow       time.Duration
+	ClusterResolveLinks bool
+	SessionTTL          time.Duration
+	AdminUsername       string
+	AdminPassword       string
 	AllowedOrigins      []string
 }
 
@@ -29,6 +68,45 @@ func Load() *Config {
 	dbPath := getEnv("DATABASE_PATH", "./rssy.db")
 
 	refreshInterval := getEnvAsDuration("FEED_REFRESH_INTERVAL", "10m")
+	fetchWorkers := getEnvAsInt("FEED_FETCH_WORKERS", 8)
+	fetchPerHost := getEnvAsInt("FEED_FETCH_PER_HOST", 2)
+	feedMaxSize := getEnvAsInt("FEED_MAX_SIZE_MB", 10)
+	maxErrors := getEnvAsInt("FEED_MAX_ERRORS", 10)
+	backoffBase := getEnvAsDuration("FEED_BACKOFF_BASE", "10m")
+	backoffMax := getEnvAsDuration("FEED_BACKOFF_MAX", "24h")
+	fetchAllowPrivate := getEnvAsBool("FEED_ALLOW_PRIVATE", false)
+	retentionInterval := getEnvAsDuration("RETENTION_INTERVAL", "1h")
+	retentionMaxAge := getEnvAsDuration("RETENTION_MAX_AGE", "0")
+	retentionMaxPosts := getEnvAsInt("RETENTION_MAX_POSTS", 0)
+	webhookInterval := getEnvAsDuration("WEBHOOK_INTERVAL", "30s")
+	webhookWorkers := getEnvAsInt("WEBHOOK_WORKERS", 4)
+	webhookTimeout := getEnvAsDuration("WEBHOOK_TIMEOUT", "10s")
+	webhookMaxAttempts := getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8)
+	webhookBackoffBase := getEnvAsDuration("WEBHOOK_BACKOFF_BASE", "1m")
+	webhookBackoffMax := getEnvAsDuration("WEBHOOK_BACKOFF_MAX", "6h")
+	webhookLogMaxAge := getEnvAsDuration("WEBHOOK_LOG_MAX_AGE", "720h")
+	webhookAllowPrivate := getEnvAsBool("WEBHOOK_ALLOW_PRIVATE", false)
+	extractInterval := getEnvAsDuration("EXTRACT_INTERVAL", "1m")
+	extractWorkers := getEnvAsInt("EXTRACT_WORKERS", 2)
+	extractHostDelay := getEnvAsDuration("EXTRACT_HOST_DELAY", "5s")
+	extractTimeout := getEnvAsDuration("EXTRACT_TIMEOUT", "20s")
+	extractMaxAttempts := getEnvAsInt("EXTRACT_MAX_ATTEMPTS", 3)
+	extractBackoffBase := getEnvAsDuration("EXTRACT_BACKOFF_BASE", "10m")
+	extractBackoffMax := getEnvAsDuration("EXTRACT_BACKOFF_MAX", "6h")
+	extractAllowPrivate := getEnvAsBool("EXTRACT_ALLOW_PRIVATE", false)
+	mediaProxyEnabled := getEnvAsBool("MEDIA_PROXY", true)
+	mediaCacheDir := getEnv("MEDIA_CACHE_DIR", "./media-cache")
+	mediaProxySecret := getEnv("MEDIA_PROXY_SECRET", "")
+	mediaPublicURL := getEnv("PUBLIC_URL", "")
+	mediaMaxImageSize := getEnvAsInt("MEDIA_MAX_IMAGE_MB", 10)
+	mediaCacheMaxSize := getEnvAsInt("MEDIA_CACHE_MAX_MB", 1024)
+	mediaTimeout := getEnvAsDuration("MEDIA_TIMEOUT", "10s")
+	mediaAllowPrivate := getEnvAsBool("MEDIA_ALLOW_PRIVATE", false)
+	clusterWindow := getEnvAsDuration("CLUSTER_WINDOW", "72h")
+	clusterResolveLinks := getEnvAsBool("CLUSTER_RESOLVE_LINKS", false)
+	sessionTTL := getEnvAsDuration("SESSION_TTL", "720h")
+	adminUsername := getEnv("ADMIN_USERNAME", "admin")
+	adminPassword := getEnv("ADMIN_PASSWORD", "")
 	allowedOrigins := getEnvAsSlice("ALLOWED_ORIGINS", []string{"http://localhost:5173"})
 
 	return &Config{
@@ -36,6 +114,45 @@ func Load() *Config {
 		Host:                host,
 		DatabasePath:        dbPath,
 		FeedRefreshInterval: refreshInterval,
+		FeedFetchWorkers:    fetchWorkers,
+		FeedFetchPerHost:    fetchPerHost,
+		FeedMaxSize:         int64(feedMaxSize) << 20,
+		FeedMaxErrors:       maxErrors,
+		FeedBackoffBase:     backoffBase,
+		FeedBackoffMax:      backoffMax,
+		FetchAllowPrivate:   fetchAllowPrivate,
+		RetentionInterval:   retentionInterval,
+		RetentionMaxAge:     retentionMaxAge,
+		RetentionMaxPosts:   retentionMaxPosts,
+		WebhookInterval:     webhookInterval,
+		WebhookWorkers:      webhookWorkers,
+		WebhookTimeout:      webhookTimeout,
+		WebhookMaxAttempts:  webhookMaxAttempts,
+		WebhookBackoffBase:  webhookBackoffBase,
+		WebhookBackoffMax:   webhookBackoffMax,
+		WebhookLogMaxAge:    webhookLogMaxAge,
+		WebhookAllowPrivate: webhookAllowPrivate,
+		ExtractInterval:     extractInterval,
+		ExtractWorkers:      extractWorkers,
+		ExtractHostDelay:    extractHostDelay,
+		ExtractTimeout:      extractTimeout,
+		ExtractMaxAttempts:  extractMaxAttempts,
+		ExtractBackoffBase:  extractBackoffBase,
+		ExtractBackoffMax:   extractBackoffMax,
+		ExtractAllowPrivate: extractAllowPrivate,
+		MediaProxyEnabled:   mediaProxyEnabled,
+		MediaCacheDir:       mediaCacheDir,
+		MediaProxySecret:    mediaProxySecret,
+		MediaPublicURL:      mediaPublicURL,
+		MediaMaxImageSize:   int64(mediaMaxImageSize) << 20,
+		MediaCacheMaxSize:   int64(mediaCacheMaxSize) << 20,
+		MediaTimeout:        mediaTimeout,
+		MediaAllowPrivate:   mediaAllowPrivate,
+		ClusterWindow:       clusterWindow,
+		ClusterResolveLinks: clusterResolveLinks,
+		SessionTTL:          sessionTTL,
+		AdminUsername:       adminUsername,
+		AdminPassword:       adminPassword,
 		AllowedOrigins:      allowedOrigins,
 	}
 }
@@ -86,3 +203,17 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return value
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		log.Printf("Invalid boolean for %s, using default: %t", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
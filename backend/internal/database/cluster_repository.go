@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// titleHashValue stores a title hash as SQLite's signed integer, with 0
+// (no hash) as NULL
+func titleHashValue(hash uint64) interface{} {
+	if hash == 0 {
+		return nil
+	}
+	return int64(hash)
+}
+
+// storySignatureColumns is the column list read by scanStorySignature
+const storySignatureColumns = `id, feed_id, COALESCE(cluster_id, id), COALESCE(url_key, ''), title_simhash`
+
+func scanStorySignature(row rowScanner) (models.StorySignature, error) {
+	var sig models.StorySignature
+	var hash sql.NullInt64
+	err := row.Scan(&sig.PostID, &sig.FeedID, &sig.ClusterID, &sig.URLKey, &hash)
+	sig.TitleHash = uint64(hash.Int64)
+	return sig, err
+}
+
+// FindStoryMatches retrieves the posts stored within window of the given
+// post that may be the same story: posts with the same URL key, and posts
+// of other feeds whose title hash has at least one of its four 16-bit
+// bands in common with the post's. Two hashes at most three bits apart
+// always share a band, so the caller only has to check the distance of
+// the posts returned.
+func (db *DB) FindStoryMatches(sig models.StorySignature, window time.Duration) ([]models.StorySignature, error) {
+	conds := []string{}
+	args := []interface{}{}
+	if sig.URLKey != "" {
+		conds = append(conds, "url_key = ?")
+		args = append(args, sig.URLKey)
+	}
+	if sig.TitleHash != 0 {
+		args = append(args, sig.FeedID)
+		bandConds := make([]string, 4)
+		for i := range bandConds {
+			bandConds[i] = "((title_simhash >> ?) & 65535) = ?"
+			args = append(args, 16*i, (sig.TitleHash>>(16*i))&0xffff)
+		}
+		conds = append(conds, "(feed_id <> ? AND title_simhash IS NOT NULL AND ("+strings.Join(bandConds, " OR ")+"))")
+	}
+	if len(conds) == 0 {
+		return nil, nil
+	}
+
+	seconds := int64(window / time.Second)
+	args = append([]interface{}{sig.PostID, seconds, seconds, sig.PostID}, args...)
+	rows, err := db.Query(`
+        WITH target AS (SELECT created_at FROM posts WHERE id = ?)
+        SELECT `+storySignatureColumns+`
+        FROM posts
+        WHERE created_at BETWEEN datetime((SELECT created_at FROM target), '-' || ? || ' seconds')
+                             AND datetime((SELECT created_at FROM target), '+' || ? || ' seconds')
+          AND id <> ?
+          AND (`+strings.Join(conds, " OR ")+`)
+        ORDER BY id ASC
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []models.StorySignature{}
+	for rows.Next() {
+		match, err := scanStorySignature(rows)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, rows.Err()
+}
+
+// GetStorySignature retrieves what story clustering knows about a post
+func (db *DB) GetStorySignature(postID int64) (models.StorySignature, error) {
+	sig, err := scanStorySignature(db.QueryRow(
+		"SELECT "+storySignatureColumns+" FROM posts WHERE id = ?", postID,
+	))
+	if err != nil {
+		return sig, translateError(err)
+	}
+	return sig, nil
+}
+
+// MergeStoryClusters joins two story clusters, keeping the older one's ID
+func (db *DB) MergeStoryClusters(a, b int64) error {
+	into, from := min(a, b), max(a, b)
+	if into == from {
+		return nil
+	}
+
+	_, err := db.Exec(
+		"UPDATE posts SET cluster_id = ? WHERE cluster_id = ? OR (cluster_id IS NULL AND id = ?)",
+		into, from, from,
+	)
+	return err
+}
+
+// SetPostURLKey records the URL key of a post's resolved link
+func (db *DB) SetPostURLKey(postID int64, urlKey string) error {
+	_, err := db.Exec(
+		"UPDATE posts SET url_key = ?, url_resolved = 1 WHERE id = ?",
+		nullIfEmpty(urlKey), postID,
+	)
+	return err
+}
+
+// GetUnsignedPosts retrieves up to limit posts after afterID, oldest
+// first, that have no story signature: those stored before signatures were
+// recorded, and those with neither a link nor a usable title. Only the
+// fields signatures are made from are filled in.
+func (db *DB) GetUnsignedPosts(afterID int64, limit int) ([]models.Post, error) {
+	rows, err := db.Query(`
+        SELECT id, feed_id, title, link FROM posts
+        WHERE id > ? AND url_key IS NULL AND title_simhash IS NULL
+        ORDER BY id ASC
+        LIMIT ?
+    `, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []models.Post{}
+	for rows.Next() {
+		var post models.Post
+		if err := rows.Scan(&post.ID, &post.FeedID, &post.Title, &post.Link); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+// SetStorySignature stores the URL key and title hash of a post
+func (db *DB) SetStorySignature(postID int64, urlKey string, titleHash uint64) error {
+	_, err := db.Exec(
+		"UPDATE posts SET url_key = ?, title_simhash = ? WHERE id = ?",
+		nullIfEmpty(urlKey), titleHashValue(titleHash), postID,
+	)
+	return err
+}
+
+// loadStoryCopies fills in the other copies of each post's story that the
+// user can see
+func (db *DB) loadStoryCopies(userID int64, posts []models.PostWithFeed) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byCluster := make(map[int64]*models.PostWithFeed, len(posts))
+	placeholders := make([]string, len(posts))
+	args := []interface{}{userID, userID}
+	for i := range posts {
+		byCluster[posts[i].ClusterID] = &posts[i]
+		placeholders[i] = "?"
+		args = append(args, posts[i].ClusterID)
+	}
+
+	rows, err := db.Query(`
+        SELECT COALESCE(p.cluster_id, p.id), p.id, p.feed_id, f.name, p.link,
+               COALESCE(ps.is_read, 0)`+postSource+`
+        WHERE `+postVisible+`
+          AND COALESCE(p.cluster_id, p.id) IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY p.id ASC
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clusterID int64
+		var c models.StoryCopy
+		if err := rows.Scan(&clusterID, &c.PostID, &c.FeedID, &c.FeedName, &c.Link, &c.IsRead); err != nil {
+			return err
+		}
+		if post, ok := byCluster[clusterID]; ok && post.ID != c.PostID {
+			post.AlsoIn = append(post.AlsoIn, c)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func TestMergeStoryClusters(t *testing.T) {
+	db, _, feed := newTestDB(t)
+
+	var ids []int64
+	for i := range 4 {
+		post := &models.Post{
+			FeedID: feed.ID,
+			Title:  fmt.Sprintf("Post %d", i),
+			Link:   fmt.Sprintf("https://example.com/%d", i),
+			GUID:   fmt.Sprintf("post-%d", i),
+		}
+		if err := db.CreatePost(post); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, post.ID)
+	}
+
+	// Join the last two, then join that story into the first post's
+	steps := [][2]int64{{ids[3], ids[2]}, {ids[2], ids[0]}}
+	for _, step := range steps {
+		if err := db.MergeStoryClusters(step[0], step[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[int64]int64{ids[0]: ids[0], ids[1]: ids[1], ids[2]: ids[0], ids[3]: ids[0]}
+	for id, cluster := range want {
+		sig, err := db.GetStorySignature(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.ClusterID != cluster {
+			t.Errorf("post %d is in cluster %d, want %d", id, sig.ClusterID, cluster)
+		}
+	}
+}
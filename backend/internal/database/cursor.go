@@ -0,0 +1,99 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// was issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// postCursor marks the last post of a page. Key is the raw stored value of
+// the sort column, so it compares exactly against the next page's rows; it
+// is nil for posts without a published date.
+type postCursor struct {
+	Sort string  `json:"s"`
+	Key  *string `json:"k"`
+	ID   int64   `json:"id"`
+}
+
+func encodeCursor(c postCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s, sort string) (*postCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c postCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if normalizeSort(c.Sort) != normalizeSort(sort) {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// cursorCondition returns the WHERE condition selecting rows that come after
+// the cursor in the given sort order. SQLite sorts NULLs first, so posts
+// without a published date come last when newest-first and first when
+// oldest-first.
+func cursorCondition(c *postCursor, sort string) (string, []interface{}) {
+	switch normalizeSort(sort) {
+	case models.SortFetched, models.SortStarred:
+		// Both columns are never NULL for the rows these orders list
+		column := "p.created_at"
+		if normalizeSort(sort) == models.SortStarred {
+			column = "ps.starred_at"
+		}
+		key := ""
+		if c.Key != nil {
+			key = *c.Key
+		}
+		return "(" + column + " < ? OR (" + column + " = ? AND p.id < ?))",
+			[]interface{}{key, key, c.ID}
+
+	case models.SortOldest:
+		if c.Key == nil {
+			return "((p.published_at IS NULL AND p.id > ?) OR p.published_at IS NOT NULL)",
+				[]interface{}{c.ID}
+		}
+		return "(p.published_at > ? OR (p.published_at = ? AND p.id > ?))",
+			[]interface{}{*c.Key, *c.Key, c.ID}
+
+	default:
+		if c.Key == nil {
+			return "(p.published_at IS NULL AND p.id < ?)", []interface{}{c.ID}
+		}
+		return "(p.published_at < ? OR (p.published_at = ? AND p.id < ?) OR p.published_at IS NULL)",
+			[]interface{}{*c.Key, *c.Key, c.ID}
+	}
+}
+
+// sortKeyColumn is the raw text of the column a sort order pages on
+func sortKeyColumn(sort string) string {
+	switch normalizeSort(sort) {
+	case models.SortFetched:
+		return "CAST(p.created_at AS TEXT)"
+	case models.SortStarred:
+		return "CAST(ps.starred_at AS TEXT)"
+	default:
+		return "CAST(p.published_at AS TEXT)"
+	}
+}
+
+func normalizeSort(sort string) string {
+	if sort == "" {
+		return models.SortNewest
+	}
+	return sort
+}
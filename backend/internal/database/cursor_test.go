@@ -0,0 +1,118 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := "2024-05-01 10:00:00+00:00"
+	cursors := []postCursor{
+		{Sort: models.SortNewest, Key: &key, ID: 12},
+		{Sort: models.SortNewest, Key: nil, ID: 3},
+		{Sort: models.SortOldest, Key: &key, ID: 1},
+		{Sort: models.SortFetched, Key: &key, ID: 99},
+		{Sort: models.SortStarred, Key: &key, ID: 7},
+	}
+
+	for _, want := range cursors {
+		got, err := decodeCursor(encodeCursor(want), want.Sort)
+		if err != nil {
+			t.Fatalf("decodeCursor(%+v) failed: %v", want, err)
+		}
+		if got.Sort != want.Sort || got.ID != want.ID || (got.Key == nil) != (want.Key == nil) ||
+			(got.Key != nil && *got.Key != *want.Key) {
+			t.Errorf("round trip of %+v gave %+v", want, got)
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	newest := encodeCursor(postCursor{Sort: models.SortNewest, ID: 1})
+
+	if _, err := decodeCursor(newest, ""); err != nil {
+		t.Errorf("newest cursor with the default sort: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   string
+	}{
+		{"not base64", "!!!", models.SortNewest},
+		{"not JSON", "bm90IGpzb24", models.SortNewest},
+		{"other sort", newest, models.SortOldest},
+	}
+	for _, tt := range tests {
+		if _, err := decodeCursor(tt.cursor, tt.sort); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: decodeCursor = %v, want %v", tt.name, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestListPostsCursorPaging(t *testing.T) {
+	db, user, feed := newTestDB(t)
+
+	// Ties and missing dates are where keyset paging goes wrong
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	published := []*time.Time{&base, &base, nil, ptr(base.Add(time.Hour)), nil, ptr(base.Add(-time.Hour)), &base}
+	for i, at := range published {
+		post := &models.Post{
+			FeedID:      feed.ID,
+			Title:       fmt.Sprintf("Post %d", i),
+			Link:        fmt.Sprintf("https://example.com/%d", i),
+			GUID:        fmt.Sprintf("post-%d", i),
+			PublishedAt: at,
+		}
+		if err := db.CreatePost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, sort := range []string{models.SortNewest, models.SortOldest, models.SortFetched} {
+		all, err := db.ListPosts(models.PostFilter{UserID: user.ID, Sort: sort, Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all.Posts) != len(published) {
+			t.Fatalf("%s: listed %d posts, want %d", sort, len(all.Posts), len(published))
+		}
+
+		var paged []int64
+		filter := models.PostFilter{UserID: user.ID, Sort: sort, Limit: 2}
+		for range len(published) {
+			page, err := db.ListPosts(filter)
+			if err != nil {
+				t.Fatalf("%s: %v", sort, err)
+			}
+			for _, post := range page.Posts {
+				paged = append(paged, post.ID)
+			}
+			if !page.HasMore {
+				break
+			}
+			filter.Cursor = page.NextCursor
+		}
+
+		if want := postIDs(all.Posts); !slices.Equal(paged, want) {
+			t.Errorf("%s: paging gave %v, want %v", sort, paged, want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func postIDs(posts []models.PostWithFeed) []int64 {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids
+}
@@ -12,6 +12,9 @@ import (
 
 type DB struct {
 	*sql.DB
+
+	// searchEnabled is set by InitSchema when FTS5 is available
+	searchEnabled bool
 }
 
 // New creates a new database connection
@@ -22,8 +25,11 @@ func New(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	// Open database connection
-	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	// Open database connection. WAL lets readers proceed while a writer is
+	// active, the busy timeout makes concurrent writers wait for SQLite's
+	// single write lock instead of failing with SQLITE_BUSY, and immediate
+	// transactions take that lock up front rather than on their first write.
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -35,7 +41,7 @@ func New(dbPath string) (*DB, error) {
 
 	log.Printf("Connected to database: %s", dbPath)
 
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
 }
 
 // Close closes the database connection
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// newTestDB opens a migrated database in a temporary directory, with one
+// user subscribed to one feed
+func newTestDB(t *testing.T) (*DB, *models.User, *models.Feed) {
+	t.Helper()
+
+	db, err := New(filepath.Join(t.TempDir(), "rssy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.CreateUser("test", "not-a-real-hash", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := db.CreateFeed(models.CreateFeedRequest{Name: "Test Feed", URL: "https://example.com/feed.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Subscribe(user.ID, feed.ID); err != nil {
+		t.Fatal(err)
+	}
+	return db, user, feed
+}
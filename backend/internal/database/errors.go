@@ -0,0 +1,53 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Sentinel errors returned by repository methods. Callers should match them
+// with errors.Is, since they are usually wrapped with more detail.
+var (
+	// ErrNotFound means the requested row does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means a write would duplicate a unique value
+	ErrConflict = errors.New("already exists")
+	// ErrConstraint means a write violates any other constraint, such as a
+	// missing foreign key or a NOT NULL column
+	ErrConstraint = errors.New("constraint violation")
+)
+
+// translateError maps "no rows" and SQLite constraint failures to the
+// sentinel errors, keeping the original error in the message
+func translateError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrConstraint {
+		return err
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	default:
+		return fmt.Errorf("%w: %v", ErrConstraint, err)
+	}
+}
+
+// requireRows returns ErrNotFound when a write matched no rows
+func requireRows(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
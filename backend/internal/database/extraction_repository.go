@@ -0,0 +1,109 @@
+package database
+
+import (
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// EnqueueExtraction queues a post's article page to be fetched, for its
+// full text or lead image. A post that is already queued is left alone.
+func (db *DB) EnqueueExtraction(postID int64) error {
+	_, err := db.Exec(`
+        INSERT OR IGNORE INTO content_extractions (post_id, status, next_attempt_at)
+        VALUES (?, ?, ?)
+    `, postID, models.ExtractionPending, time.Now().UTC())
+	return err
+}
+
+// GetDueExtractions retrieves up to limit pending extractions whose next
+// attempt is due, oldest first. Posts are skipped once their feed no
+// longer fetches full text, unless it fetches page images and the post
+// still has no image, or the post's link is still to be resolved.
+func (db *DB) GetDueExtractions(limit int) ([]models.PendingExtraction, error) {
+	rows, err := db.Query(`
+        SELECT e.post_id, p.link, e.attempts, f.fetch_full_text,
+               COALESCE(p.image_url, '') = '', NOT p.url_resolved
+        FROM content_extractions e
+        JOIN posts p ON p.id = e.post_id
+        JOIN feeds f ON f.id = p.feed_id
+        WHERE e.status = ? AND e.next_attempt_at <= ?
+          AND (f.fetch_full_text = 1 OR (f.fetch_page_image = 1 AND COALESCE(p.image_url, '') = '')
+               OR p.url_resolved = 0)
+        ORDER BY e.next_attempt_at ASC, e.post_id ASC
+        LIMIT ?
+    `, models.ExtractionPending, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	extractions := []models.PendingExtraction{}
+	for rows.Next() {
+		var e models.PendingExtraction
+		if err := rows.Scan(&e.PostID, &e.Link, &e.Attempts, &e.FullText, &e.Image, &e.ResolveURL); err != nil {
+			return nil, err
+		}
+		extractions = append(extractions, e)
+	}
+
+	return extractions, rows.Err()
+}
+
+// RecordExtractionSuccess stores a post's extracted article, and its plain
+// text for search, and removes it from the queue
+func (db *DB) RecordExtractionSuccess(postID int64, fullContent, text string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"UPDATE posts SET full_content = ?, text_content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		fullContent, text, postID,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM content_extractions WHERE post_id = ?", postID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RecordPageImage stores a lead image found on a post's article page
+func (db *DB) RecordPageImage(postID int64, imageURL, source string) error {
+	_, err := db.Exec(
+		"UPDATE posts SET image_url = ?, image_source = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		imageURL, source, postID,
+	)
+	return err
+}
+
+// CompleteExtraction removes a post from the queue once its page has been
+// fetched and nothing is left to extract
+func (db *DB) CompleteExtraction(postID int64) error {
+	_, err := db.Exec("DELETE FROM content_extractions WHERE post_id = ?", postID)
+	return err
+}
+
+// RecordExtractionFailure records a failed attempt. The extraction is
+// retried at retryAt, or marked failed for good when retryAt is nil.
+func (db *DB) RecordExtractionFailure(postID int64, message string, retryAt *time.Time) error {
+	status := models.ExtractionPending
+	if retryAt == nil {
+		status = models.ExtractionFailed
+	} else {
+		utc := retryAt.UTC()
+		retryAt = &utc
+	}
+
+	_, err := db.Exec(`
+        UPDATE content_extractions
+        SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+        WHERE post_id = ?
+    `, status, message, retryAt, postID)
+	return err
+}
@@ -1,23 +1,97 @@
 package database
 
 import (
-	"database/sql"
-	"fmt"
+	"strings"
 	"time"
 
 	"github.com/justanotherspy/rssy/internal/models"
 )
 
-// GetAllFeeds retrieves all feeds
-func (db *DB) GetAllFeeds() ([]models.Feed, error) {
-	query := `
-        SELECT id, name, url, category, site_url, description, is_active,
-               last_fetched_at, error_count, last_error, created_at, updated_at
+// feedColumns is the column list shared by every query that returns feeds,
+// in the order expected by scanFeed
+const feedColumns = `id, name, url, category, site_url, description, is_active,
+               last_fetched_at, error_count, last_error, last_error_at,
+               etag, last_modified, refresh_interval, next_fetch_at,
+               retention_max_age, retention_max_posts, fetch_full_text,
+               fetch_page_image, created_at, updated_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFeed(row rowScanner, feed *models.Feed, extra ...interface{}) error {
+	dest := []interface{}{
+		&feed.ID, &feed.Name, &feed.URL, &feed.Category, &feed.SiteURL,
+		&feed.Description, &feed.IsActive, &feed.LastFetchedAt,
+		&feed.ErrorCount, &feed.LastError, &feed.LastErrorAt,
+		&feed.ETag, &feed.LastModified, &feed.RefreshInterval, &feed.NextFetchAt,
+		&feed.RetentionMaxAge, &feed.RetentionMaxPosts, &feed.FetchFullText,
+		&feed.FetchPageImage, &feed.CreatedAt, &feed.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// userFeeds restricts a feed query to one user's subscriptions; it takes the
+// user ID as its parameter
+const userFeeds = `feeds.id IN (SELECT feed_id FROM subscriptions WHERE user_id = ?)`
+
+// GetUserFeeds retrieves the feeds a user subscribes to
+func (db *DB) GetUserFeeds(userID int64) ([]models.Feed, error) {
+	return db.queryFeeds(`
+        SELECT `+feedColumns+`
         FROM feeds
+        WHERE `+userFeeds+`
         ORDER BY name ASC
-    `
+    `, userID)
+}
+
+// GetSubscribedFeeds retrieves every feed with at least one subscriber.
+// Each is fetched once no matter how many users share it.
+func (db *DB) GetSubscribedFeeds() ([]models.Feed, error) {
+	return db.queryFeeds(`
+        SELECT ` + feedColumns + `
+        FROM feeds
+        WHERE id IN (SELECT feed_id FROM subscriptions)
+        ORDER BY name ASC
+    `)
+}
 
-	rows, err := db.Query(query)
+// GetSubscribedFeedsChangedSince retrieves the subscribed feeds that were
+// created, edited or subscribed to at or after since. Timestamps
+// are only kept to the second, so feeds changed in since's second are
+// included too.
+func (db *DB) GetSubscribedFeedsChangedSince(since time.Time) ([]models.Feed, error) {
+	return db.queryFeeds(`
+        SELECT `+feedColumns+`
+        FROM feeds
+        WHERE changed_at >= ? AND id IN (SELECT feed_id FROM subscriptions)
+    `, since.UTC().Format(time.DateTime))
+}
+
+// GetSubscribedFeedsByIDs retrieves those of the given feeds that still
+// have a subscriber
+func (db *DB) GetSubscribedFeedsByIDs(ids []int64) ([]models.Feed, error) {
+	if len(ids) == 0 {
+		return []models.Feed{}, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return db.queryFeeds(`
+        SELECT `+feedColumns+`
+        FROM feeds
+        WHERE id IN (`+strings.Join(placeholders, ", ")+`)
+          AND id IN (SELECT feed_id FROM subscriptions)
+    `, args...)
+}
+
+func (db *DB) queryFeeds(query string, args ...interface{}) ([]models.Feed, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -26,41 +100,164 @@ func (db *DB) GetAllFeeds() ([]models.Feed, error) {
 	feeds := []models.Feed{}
 	for rows.Next() {
 		var feed models.Feed
+		if err := scanFeed(rows, &feed); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, rows.Err()
+}
+
+// feedCountsJoin joins each feed to its post counts, counting unread posts
+// by one user's read state and leaving out posts their filter rules
+// dropped. It takes the user ID as its parameter.
+const feedCountsJoin = `
+        LEFT JOIN (
+            SELECT p.feed_id,
+                   COUNT(*) as total_count,
+                   SUM(CASE WHEN COALESCE(ps.is_read, 0) = 0 THEN 1 ELSE 0 END) as unread_count
+            FROM posts p
+            LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = ?
+            WHERE COALESCE(ps.is_hidden, 0) = 0
+            GROUP BY p.feed_id
+        ) c ON c.feed_id = feeds.id`
+
+// GetAllFeedsWithCounts retrieves a user's feeds along with their unread
+// and total post counts
+func (db *DB) GetAllFeedsWithCounts(userID int64) ([]models.FeedWithCounts, error) {
+	query := `
+        SELECT ` + feedColumns + `,
+               COALESCE(c.unread_count, 0), COALESCE(c.total_count, 0)
+        FROM feeds` + feedCountsJoin + `
+        WHERE ` + userFeeds + `
+        ORDER BY name ASC
+    `
+
+	rows, err := db.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []models.FeedWithCounts{}
+	for rows.Next() {
+		var feed models.FeedWithCounts
+		if err := scanFeed(rows, &feed.Feed, &feed.UnreadCount, &feed.TotalCount); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, rows.Err()
+}
+
+// GetFeedCounts retrieves just the unread and total post counts of a
+// user's feeds
+func (db *DB) GetFeedCounts(userID int64) ([]models.FeedCounts, error) {
+	query := `
+        SELECT feeds.id, COALESCE(c.unread_count, 0), COALESCE(c.total_count, 0)
+        FROM feeds` + feedCountsJoin + `
+        WHERE ` + userFeeds + `
+        ORDER BY feeds.id ASC
+    `
+
+	rows, err := db.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.FeedCounts{}
+	for rows.Next() {
+		var c models.FeedCounts
+		if err := rows.Scan(&c.FeedID, &c.UnreadCount, &c.TotalCount); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetCategories retrieves the categories of a user's feeds with their feed
+// count and aggregated unread and total post counts
+func (db *DB) GetCategories(userID int64) ([]models.Category, error) {
+	query := `
+        SELECT NULLIF(feeds.category, '') as name,
+               COUNT(*),
+               COALESCE(SUM(c.unread_count), 0),
+               COALESCE(SUM(c.total_count), 0)
+        FROM feeds` + feedCountsJoin + `
+        WHERE ` + userFeeds + `
+        GROUP BY NULLIF(feeds.category, '')
+        ORDER BY name IS NULL, name ASC
+    `
+
+	rows, err := db.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var category models.Category
 		err := rows.Scan(
-			&feed.ID, &feed.Name, &feed.URL, &feed.Category, &feed.SiteURL,
-			&feed.Description, &feed.IsActive, &feed.LastFetchedAt,
-			&feed.ErrorCount, &feed.LastError, &feed.CreatedAt, &feed.UpdatedAt,
+			&category.Name, &category.FeedCount,
+			&category.UnreadCount, &category.TotalCount,
 		)
 		if err != nil {
 			return nil, err
 		}
-		feeds = append(feeds, feed)
+		categories = append(categories, category)
 	}
 
-	return feeds, nil
+	return categories, rows.Err()
 }
 
 // GetFeedByID retrieves a feed by ID
 func (db *DB) GetFeedByID(id int64) (*models.Feed, error) {
 	query := `
-        SELECT id, name, url, category, site_url, description, is_active,
-               last_fetched_at, error_count, last_error, created_at, updated_at
+        SELECT ` + feedColumns + `
         FROM feeds
         WHERE id = ?
     `
 
 	var feed models.Feed
-	err := db.QueryRow(query, id).Scan(
-		&feed.ID, &feed.Name, &feed.URL, &feed.Category, &feed.SiteURL,
-		&feed.Description, &feed.IsActive, &feed.LastFetchedAt,
-		&feed.ErrorCount, &feed.LastError, &feed.CreatedAt, &feed.UpdatedAt,
-	)
+	if err := scanFeed(db.QueryRow(query, id), &feed); err != nil {
+		return nil, translateError(err)
+	}
+
+	return &feed, nil
+}
+
+// GetUserFeed retrieves a feed by ID if the user subscribes to it
+func (db *DB) GetUserFeed(userID, id int64) (*models.Feed, error) {
+	query := `
+        SELECT ` + feedColumns + `
+        FROM feeds
+        WHERE id = ? AND ` + userFeeds
 
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("feed not found")
+	var feed models.Feed
+	if err := scanFeed(db.QueryRow(query, id, userID), &feed); err != nil {
+		return nil, translateError(err)
 	}
-	if err != nil {
-		return nil, err
+
+	return &feed, nil
+}
+
+// GetFeedByURL retrieves a feed by its exact URL
+func (db *DB) GetFeedByURL(url string) (*models.Feed, error) {
+	query := `
+        SELECT ` + feedColumns + `
+        FROM feeds
+        WHERE url = ?
+    `
+
+	var feed models.Feed
+	if err := scanFeed(db.QueryRow(query, url), &feed); err != nil {
+		return nil, translateError(err)
 	}
 
 	return &feed, nil
@@ -69,23 +266,19 @@ func (db *DB) GetFeedByID(id int64) (*models.Feed, error) {
 // CreateFeed creates a new feed
 func (db *DB) CreateFeed(req models.CreateFeedRequest) (*models.Feed, error) {
 	query := `
-        INSERT INTO feeds (name, url, category, site_url, description)
-        VALUES (?, ?, ?, ?, ?)
-        RETURNING id, name, url, category, site_url, description, is_active,
-                  last_fetched_at, error_count, last_error, created_at, updated_at
-    `
+        INSERT INTO feeds (name, url, category, site_url, description, fetch_full_text,
+                           fetch_page_image, changed_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+        RETURNING ` + feedColumns
 
 	var feed models.Feed
-	err := db.QueryRow(
-		query, req.Name, req.URL, req.Category, req.SiteURL, req.Description,
-	).Scan(
-		&feed.ID, &feed.Name, &feed.URL, &feed.Category, &feed.SiteURL,
-		&feed.Description, &feed.IsActive, &feed.LastFetchedAt,
-		&feed.ErrorCount, &feed.LastError, &feed.CreatedAt, &feed.UpdatedAt,
-	)
+	err := scanFeed(db.QueryRow(
+		query, req.Name, req.URL, req.Category, req.SiteURL, req.Description, req.FetchFullText,
+		req.FetchPageImage,
+	), &feed)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &feed, nil
@@ -94,7 +287,7 @@ func (db *DB) CreateFeed(req models.CreateFeedRequest) (*models.Feed, error) {
 // UpdateFeed updates an existing feed
 func (db *DB) UpdateFeed(id int64, req models.UpdateFeedRequest) (*models.Feed, error) {
 	// Build dynamic update query
-	query := "UPDATE feeds SET updated_at = CURRENT_TIMESTAMP"
+	query := "UPDATE feeds SET updated_at = CURRENT_TIMESTAMP, changed_at = CURRENT_TIMESTAMP"
 	args := []interface{}{}
 
 	if req.Name != nil {
@@ -120,30 +313,175 @@ func (db *DB) UpdateFeed(id int64, req models.UpdateFeedRequest) (*models.Feed,
 	if req.IsActive != nil {
 		query += ", is_active = ?"
 		args = append(args, *req.IsActive)
+		// Reactivating a feed gives it a clean slate so it is not
+		// immediately backed off or deactivated again
+		if *req.IsActive {
+			query += ", error_count = 0, last_error = NULL, last_error_at = NULL"
+		}
+	}
+	if req.FetchFullText != nil {
+		query += ", fetch_full_text = ?"
+		args = append(args, *req.FetchFullText)
+	}
+	if req.FetchPageImage != nil {
+		query += ", fetch_page_image = ?"
+		args = append(args, *req.FetchPageImage)
+	}
+	// Non-positive values clear the override so the global default applies
+	for _, override := range []struct {
+		column string
+		value  *int
+	}{
+		{"refresh_interval", req.RefreshInterval},
+		{"retention_max_age", req.RetentionMaxAge},
+		{"retention_max_posts", req.RetentionMaxPosts},
+	} {
+		if override.value == nil {
+			continue
+		}
+		query += ", " + override.column + " = ?"
+		if *override.value > 0 {
+			args = append(args, *override.value)
+		} else {
+			args = append(args, nil)
+		}
 	}
 
-	query += " WHERE id = ?"
+	query += " WHERE id = ? RETURNING " + feedColumns
 	args = append(args, id)
 
-	_, err := db.Exec(query, args...)
+	var feed models.Feed
+	if err := scanFeed(db.QueryRow(query, args...), &feed); err != nil {
+		return nil, translateError(err)
+	}
+
+	return &feed, nil
+}
+
+// Subscribe adds a feed to a user's subscriptions. It returns ErrConflict
+// if the user already subscribes to it.
+func (db *DB) Subscribe(userID, feedID int64) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO subscriptions (user_id, feed_id) VALUES (?, ?)", userID, feedID); err != nil {
+		return translateError(err)
+	}
+	// Touch the feed so the poller schedules it again if nobody
+	// subscribed to it before
+	if _, err := tx.Exec("UPDATE feeds SET changed_at = CURRENT_TIMESTAMP WHERE id = ?", feedID); err != nil {
+		return err
 	}
 
-	return db.GetFeedByID(id)
+	return tx.Commit()
 }
 
-// DeleteFeed deletes a feed
-func (db *DB) DeleteFeed(id int64) error {
-	_, err := db.Exec("DELETE FROM feeds WHERE id = ?", id)
-	return err
+// CountSubscribers returns how many users subscribe to a feed
+func (db *DB) CountSubscribers(feedID int64) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE feed_id = ?", feedID).Scan(&count)
+	return count, err
+}
+
+// Unsubscribe removes a feed from a user's subscriptions. A feed nobody
+// subscribes to any more is deleted along with its posts, unless someone
+// has starred one of them.
+func (db *DB) Unsubscribe(userID, feedID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM subscriptions WHERE user_id = ? AND feed_id = ?", userID, feedID)
+	if err != nil {
+		return err
+	}
+	if err := requireRows(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        DELETE FROM feeds
+        WHERE id = ?
+          AND NOT EXISTS (SELECT 1 FROM subscriptions WHERE feed_id = feeds.id)
+          AND NOT EXISTS (
+              SELECT 1 FROM post_states ps
+              JOIN posts p ON p.id = ps.post_id
+              WHERE p.feed_id = feeds.id AND ps.is_starred = 1
+          )
+    `, feedID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // UpdateFeedLastFetched updates the last fetched timestamp
 func (db *DB) UpdateFeedLastFetched(id int64, fetchTime time.Time) error {
 	_, err := db.Exec(
-		"UPDATE feeds SET last_fetched_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		"UPDATE feeds SET last_fetched_at = ? WHERE id = ?",
 		fetchTime, id,
 	)
 	return err
 }
+
+// UpdateFeedCacheHeaders stores the HTTP validators returned by the feed's
+// server so the next fetch can be made conditional
+func (db *DB) UpdateFeedCacheHeaders(id int64, etag, lastModified string) error {
+	_, err := db.Exec(
+		"UPDATE feeds SET etag = ?, last_modified = ? WHERE id = ?",
+		nullIfEmpty(etag), nullIfEmpty(lastModified), id,
+	)
+	return err
+}
+
+// nullIfEmpty maps an empty string to SQL NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// UpdateFeedNextFetch stores the earliest time the feed should be fetched
+// again; nil clears it
+func (db *DB) UpdateFeedNextFetch(id int64, next *time.Time) error {
+	_, err := db.Exec("UPDATE feeds SET next_fetch_at = ? WHERE id = ?", next, id)
+	return err
+}
+
+// RecordFeedError increments a feed's error count and stores the error
+// message. When maxErrors is positive and the count reaches it, the feed is
+// deactivated. It returns the updated feed.
+func (db *DB) RecordFeedError(id int64, message string, maxErrors int) (*models.Feed, error) {
+	query := `
+        UPDATE feeds
+        SET error_count = error_count + 1,
+            last_error = ?,
+            last_error_at = CURRENT_TIMESTAMP,
+            is_active = CASE WHEN ? > 0 AND error_count + 1 >= ? THEN 0 ELSE is_active END
+        WHERE id = ?
+        RETURNING ` + feedColumns
+
+	var feed models.Feed
+	if err := scanFeed(db.QueryRow(query, message, maxErrors, maxErrors, id), &feed); err != nil {
+		return nil, err
+	}
+
+	return &feed, nil
+}
+
+// ClearFeedError resets a feed's error state after a successful fetch
+func (db *DB) ClearFeedError(id int64) error {
+	_, err := db.Exec(`
+        UPDATE feeds
+        SET error_count = 0, last_error = NULL, last_error_at = NULL
+        WHERE id = ? AND (error_count <> 0 OR last_error IS NOT NULL)
+    `, id)
+	return err
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func TestGetSubscribedFeedsChangedSince(t *testing.T) {
+	db, user, feed := newTestDB(t)
+
+	if _, err := db.Exec("UPDATE feeds SET changed_at = '2000-01-01 00:00:00'"); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	changed := func() int {
+		t.Helper()
+		feeds, err := db.GetSubscribedFeedsChangedSince(since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(feeds)
+	}
+
+	// Fetching a feed is not a change to its schedule
+	if err := db.UpdateFeedLastFetched(feed.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateFeedCacheHeaders(feed.ID, `"etag"`, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RecordFeedError(feed.ID, "timeout", 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := changed(); n != 0 {
+		t.Errorf("%d feeds changed after fetching, want 0", n)
+	}
+
+	interval := 3600
+	if _, err := db.UpdateFeed(feed.ID, models.UpdateFeedRequest{RefreshInterval: &interval}); err != nil {
+		t.Fatal(err)
+	}
+	if n := changed(); n != 1 {
+		t.Errorf("%d feeds changed after editing, want 1", n)
+	}
+
+	// A feed nobody subscribes to is never listed; subscribing lists it
+	other, err := db.CreateFeed(models.CreateFeedRequest{Name: "Other", URL: "https://example.org/feed.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := changed(); n != 1 {
+		t.Errorf("%d feeds changed after creating an unsubscribed feed, want 1", n)
+	}
+	if _, err := db.Exec("UPDATE feeds SET changed_at = '2000-01-01 00:00:00' WHERE id = ?", other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Subscribe(user.ID, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := changed(); n != 2 {
+		t.Errorf("%d feeds changed after subscribing, want 2", n)
+	}
+}
+
+func TestRecordFeedError(t *testing.T) {
+	db, _, feed := newTestDB(t)
+
+	for i := 1; i <= 3; i++ {
+		got, err := db.RecordFeedError(feed.ID, "boom", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ErrorCount != i || got.LastError == nil || *got.LastError != "boom" || got.LastErrorAt == nil {
+			t.Errorf("after %d errors: count %d, last error %v at %v", i, got.ErrorCount, got.LastError, got.LastErrorAt)
+		}
+		if wantActive := i < 3; got.IsActive != wantActive {
+			t.Errorf("after %d errors: active = %v, want %v", i, got.IsActive, wantActive)
+		}
+	}
+
+	if err := db.ClearFeedError(feed.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetFeedByID(feed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ErrorCount != 0 || got.LastError != nil || got.LastErrorAt != nil {
+		t.Errorf("after clearing: count %d, last error %v at %v", got.ErrorCount, got.LastError, got.LastErrorAt)
+	}
+}
+
+func TestRecordFeedErrorWithoutLimit(t *testing.T) {
+	db, _, feed := newTestDB(t)
+
+	for range 5 {
+		got, err := db.RecordFeedError(feed.ID, "boom", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.IsActive {
+			t.Fatalf("feed deactivated after %d errors with no limit", got.ErrorCount)
+		}
+	}
+}
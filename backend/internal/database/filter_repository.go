@@ -0,0 +1,205 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+const filterRuleColumns = `id, user_id, name, field, match_type, pattern, feed_id,
+               category, action, COALESCE(tag, ''), is_active, created_at, updated_at`
+
+func scanFilterRule(row rowScanner, rule *models.FilterRule) error {
+	return row.Scan(
+		&rule.ID, &rule.UserID, &rule.Name, &rule.Field, &rule.MatchType,
+		&rule.Pattern, &rule.FeedID, &rule.Category, &rule.Action, &rule.Tag,
+		&rule.IsActive, &rule.CreatedAt, &rule.UpdatedAt,
+	)
+}
+
+func (db *DB) queryFilterRules(query string, args ...interface{}) ([]models.FilterRule, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.FilterRule{}
+	for rows.Next() {
+		var rule models.FilterRule
+		if err := scanFilterRule(rows, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// GetFilterRules retrieves a user's filter rules
+func (db *DB) GetFilterRules(userID int64) ([]models.FilterRule, error) {
+	return db.queryFilterRules(
+		"SELECT "+filterRuleColumns+" FROM filter_rules WHERE user_id = ? ORDER BY id ASC", userID,
+	)
+}
+
+// GetFilterRule retrieves one of a user's filter rules
+func (db *DB) GetFilterRule(userID, id int64) (*models.FilterRule, error) {
+	var rule models.FilterRule
+	err := scanFilterRule(db.QueryRow(
+		"SELECT "+filterRuleColumns+" FROM filter_rules WHERE id = ? AND user_id = ?", id, userID,
+	), &rule)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &rule, nil
+}
+
+// CreateFilterRule stores a new rule for rule.UserID, filling in its ID and
+// timestamps
+func (db *DB) CreateFilterRule(rule *models.FilterRule) error {
+	err := scanFilterRule(db.QueryRow(`
+        INSERT INTO filter_rules (user_id, name, field, match_type, pattern,
+                                  feed_id, category, action, tag, is_active)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        RETURNING `+filterRuleColumns,
+		rule.UserID, rule.Name, rule.Field, rule.MatchType, rule.Pattern,
+		rule.FeedID, rule.Category, rule.Action, nullIfEmpty(rule.Tag), rule.IsActive,
+	), rule)
+	return translateError(err)
+}
+
+// UpdateFilterRule saves every field of an existing rule of rule.UserID
+func (db *DB) UpdateFilterRule(rule *models.FilterRule) error {
+	err := scanFilterRule(db.QueryRow(`
+        UPDATE filter_rules
+        SET name = ?, field = ?, match_type = ?, pattern = ?, feed_id = ?,
+            category = ?, action = ?, tag = ?, is_active = ?,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND user_id = ?
+        RETURNING `+filterRuleColumns,
+		rule.Name, rule.Field, rule.MatchType, rule.Pattern, rule.FeedID,
+		rule.Category, rule.Action, nullIfEmpty(rule.Tag), rule.IsActive,
+		rule.ID, rule.UserID,
+	), rule)
+	return translateError(err)
+}
+
+// DeleteFilterRule deletes one of a user's filter rules. Posts it already
+// acted on keep their state and tags.
+func (db *DB) DeleteFilterRule(userID, id int64) error {
+	result, err := db.Exec("DELETE FROM filter_rules WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRows(result)
+}
+
+// GetFeedFilterRules retrieves the active rules of every subscriber of a
+// feed that apply to it, grouped by user
+func (db *DB) GetFeedFilterRules(feed *models.Feed) ([]models.FilterRule, error) {
+	return db.queryFilterRules(`
+        SELECT `+filterRuleColumns+`
+        FROM filter_rules
+        WHERE is_active = 1
+          AND user_id IN (SELECT user_id FROM subscriptions WHERE feed_id = ?)
+          AND (feed_id IS NULL OR feed_id = ?)
+          AND (category IS NULL OR category = ?)
+        ORDER BY user_id ASC, id ASC
+    `, feed.ID, feed.ID, feed.Category)
+}
+
+// GetFeedSubscribers retrieves the IDs of the users subscribed to a feed
+func (db *DB) GetFeedSubscribers(feedID int64) ([]int64, error) {
+	rows, err := db.Query("SELECT user_id FROM subscriptions WHERE feed_id = ? ORDER BY user_id ASC", feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// ApplyFilterActions records what each user's filter rules did to a new
+// post: it is marked read, starred or hidden for them and tagged. A
+// dropped post is also marked read so retention can prune it.
+func (db *DB) ApplyFilterActions(postID int64, actions map[int64]models.FilterActions) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for userID, a := range actions {
+		if a.MarkRead || a.Star || a.Drop {
+			_, err := tx.Exec(`
+                INSERT INTO post_states (user_id, post_id, is_read, is_starred, starred_at, is_hidden)
+                VALUES (?, ?, ?, ?, CASE WHEN ? THEN CURRENT_TIMESTAMP END, ?)
+                ON CONFLICT (user_id, post_id) DO UPDATE SET
+                    is_read = excluded.is_read,
+                    is_starred = excluded.is_starred,
+                    starred_at = excluded.starred_at,
+                    is_hidden = excluded.is_hidden
+            `, userID, postID, a.MarkRead || a.Drop, a.Star, a.Star, a.Drop)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, tag := range a.Tags {
+			_, err := tx.Exec(
+				"INSERT OR IGNORE INTO post_tags (user_id, post_id, tag) VALUES (?, ?, ?)",
+				userID, postID, tag,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetHiddenPostIDs returns which of the posts the user's filter rules
+// dropped
+func (db *DB) GetHiddenPostIDs(userID int64, postIDs []int64) (map[int64]bool, error) {
+	hidden := make(map[int64]bool)
+	if len(postIDs) == 0 {
+		return hidden, nil
+	}
+
+	placeholders := make([]string, len(postIDs))
+	args := []interface{}{userID}
+	for i, id := range postIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(`
+        SELECT post_id FROM post_states
+        WHERE user_id = ? AND is_hidden = 1 AND post_id IN (`+strings.Join(placeholders, ", ")+`)
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		hidden[id] = true
+	}
+
+	return hidden, rows.Err()
+}
@@ -0,0 +1,611 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this binary does not know about, i.e. it was last opened by a newer rssy
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// migration is a single, ordered schema change. Migrations are append-only:
+// once released, a migration must never be edited or renumbered.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// MigrationStatus reports whether a known migration has been applied
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create feeds and posts",
+		// IF NOT EXISTS lets databases created before migrations existed
+		// adopt this as their baseline
+		up: execSQL(`
+            CREATE TABLE IF NOT EXISTS feeds (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                name TEXT NOT NULL,
+                url TEXT NOT NULL UNIQUE,
+                category TEXT,
+                site_url TEXT,
+                description TEXT,
+                is_active BOOLEAN NOT NULL DEFAULT 1,
+                last_fetched_at DATETIME,
+                error_count INTEGER DEFAULT 0,
+                last_error TEXT,
+                created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_feeds_is_active ON feeds(is_active);
+            CREATE INDEX IF NOT EXISTS idx_feeds_last_fetched ON feeds(last_fetched_at);
+
+            CREATE TABLE IF NOT EXISTS posts (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                feed_id INTEGER NOT NULL,
+                title TEXT NOT NULL,
+                link TEXT NOT NULL,
+                description TEXT,
+                content TEXT,
+                author TEXT,
+                published_at DATETIME,
+                image_url TEXT,
+                guid TEXT NOT NULL,
+                is_read BOOLEAN NOT NULL DEFAULT 0,
+                created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE,
+                UNIQUE(feed_id, guid)
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_posts_feed_id ON posts(feed_id);
+            CREATE INDEX IF NOT EXISTS idx_posts_published_at ON posts(published_at DESC);
+            CREATE INDEX IF NOT EXISTS idx_posts_is_read ON posts(is_read);
+            CREATE UNIQUE INDEX IF NOT EXISTS idx_posts_feed_guid ON posts(feed_id, guid);
+        `),
+	},
+	{
+		version: 2,
+		name:    "add feed fetch state",
+		// Some databases already gained these columns before migrations
+		// were introduced, so each is only added if missing
+		up: addColumns("feeds", []columnDef{
+			{"etag", "TEXT"},
+			{"last_modified", "TEXT"},
+			{"last_error_at", "DATETIME"},
+			{"refresh_interval", "INTEGER"},
+			{"next_fetch_at", "DATETIME"},
+		}),
+	},
+	{
+		version: 3,
+		name:    "normalize post timestamps to UTC",
+		// Posts were stored with their feed's UTC offset, which breaks
+		// ordering and range filters on the text column
+		up: execSQL(`
+            UPDATE posts
+            SET published_at = strftime('%Y-%m-%d %H:%M:%S', published_at) || '+00:00'
+            WHERE published_at IS NOT NULL
+              AND strftime('%Y-%m-%d %H:%M:%S', published_at) IS NOT NULL
+              AND published_at NOT LIKE '%+00:00';
+
+            CREATE INDEX IF NOT EXISTS idx_posts_published_id ON posts(published_at DESC, id DESC);
+        `),
+	},
+	{
+		version: 4,
+		name:    "index posts by feed and read state",
+		// Covers the per-feed unread/total counts so they never touch the
+		// posts table itself
+		up: execSQL(`
+            CREATE INDEX IF NOT EXISTS idx_posts_feed_read ON posts(feed_id, is_read);
+        `),
+	},
+	{
+		version: 5,
+		name:    "add starred posts",
+		up: steps(
+			addColumns("posts", []columnDef{
+				{"is_starred", "BOOLEAN NOT NULL DEFAULT 0"},
+				{"starred_at", "DATETIME"},
+			}),
+			execSQL(`
+                CREATE INDEX IF NOT EXISTS idx_posts_starred ON posts(starred_at DESC, id DESC)
+                WHERE is_starred = 1;
+            `),
+		),
+	},
+	{
+		version: 6,
+		name:    "add post retention",
+		// Tombstones remember the GUIDs of pruned posts so the next fetch
+		// does not insert them again
+		up: steps(
+			addColumns("feeds", []columnDef{
+				{"retention_max_age", "INTEGER"},
+				{"retention_max_posts", "INTEGER"},
+			}),
+			execSQL(`
+                CREATE TABLE IF NOT EXISTS post_tombstones (
+                    feed_id INTEGER NOT NULL,
+                    guid TEXT NOT NULL,
+                    pruned_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    PRIMARY KEY (feed_id, guid),
+                    FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+                );
+            `),
+		),
+	},
+	{
+		version: 7,
+		name:    "add users, subscriptions and per-user post state",
+		// Read and star state moves to post_states. The old columns on
+		// posts are left in place only so the first user created can adopt
+		// them (see CreateUser); nothing else reads them.
+		up: execSQL(`
+            CREATE TABLE IF NOT EXISTS users (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+                password_hash TEXT NOT NULL,
+                is_admin BOOLEAN NOT NULL DEFAULT 0,
+                created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+            );
+
+            CREATE TABLE IF NOT EXISTS auth_tokens (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                token_hash TEXT NOT NULL UNIQUE,
+                kind TEXT NOT NULL,
+                name TEXT NOT NULL DEFAULT '',
+                expires_at DATETIME,
+                last_used_at DATETIME,
+                created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_auth_tokens_user ON auth_tokens(user_id);
+
+            CREATE TABLE IF NOT EXISTS subscriptions (
+                user_id INTEGER NOT NULL,
+                feed_id INTEGER NOT NULL,
+                created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                PRIMARY KEY (user_id, feed_id),
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_subscriptions_feed ON subscriptions(feed_id);
+
+            CREATE TABLE IF NOT EXISTS post_states (
+                user_id INTEGER NOT NULL,
+                post_id INTEGER NOT NULL,
+                is_read BOOLEAN NOT NULL DEFAULT 0,
+                is_starred BOOLEAN NOT NULL DEFAULT 0,
+                starred_at DATETIME,
+                PRIMARY KEY (user_id, post_id),
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_post_states_post ON post_states(post_id);
+            CREATE INDEX IF NOT EXISTS idx_post_states_starred
+                ON post_states(user_id, starred_at DESC, post_id DESC) WHERE is_starred = 1;
+
+            DROP INDEX IF EXISTS idx_posts_is_read;
+            DROP INDEX IF EXISTS idx_posts_feed_read;
+            DROP INDEX IF EXISTS idx_posts_starred;
+        `),
+	},
+	{
+		version: 8,
+		name:    "add webhooks and delivery outbox",
+		// Deliveries keep their own copy of the payload so they can be
+		// retried after the post is pruned
+		up: execSQL(`
+            CREATE TABLE IF NOT EXISTS webhooks (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                url TEXT NOT NULL,
+                secret TEXT NOT NULL,
+                feed_id INTEGER,
+                category TEXT,
+                is_active BOOLEAN NOT NULL DEFAULT 1,
+                created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks(user_id);
+
+            CREATE TABLE IF NOT EXISTS webhook_deliveries (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                webhook_id INTEGER NOT NULL,
+                post_id INTEGER,
+                event TEXT NOT NULL,
+                payload TEXT NOT NULL,
+                status TEXT NOT NULL DEFAULT 'pending',
+                attempts INTEGER NOT NULL DEFAULT 0,
+                next_attempt_at DATETIME,
+                last_status_code INTEGER,
+                last_error TEXT,
+                created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                delivered_at DATETIME,
+                FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE,
+                FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE SET NULL
+            );
+
+            CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook
+                ON webhook_deliveries(webhook_id, id DESC);
+            CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due
+                ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+            CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_post ON webhook_deliveries(post_id);
+        `),
+	},
+	{
+		version: 9,
+		name:    "add filter rules and post tags",
+		// A post dropped by a rule is kept if another subscriber wants it;
+		// it is only hidden from the rule's owner
+		up: steps(
+			execSQL(`
+                CREATE TABLE IF NOT EXISTS filter_rules (
+                    id INTEGER PRIMARY KEY AUTOINCREMENT,
+                    user_id INTEGER NOT NULL,
+                    name TEXT NOT NULL DEFAULT '',
+                    field TEXT NOT NULL,
+                    match_type TEXT NOT NULL,
+                    pattern TEXT NOT NULL,
+                    feed_id INTEGER,
+                    category TEXT,
+                    action TEXT NOT NULL,
+                    tag TEXT,
+                    is_active BOOLEAN NOT NULL DEFAULT 1,
+                    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                    FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+                );
+
+                CREATE INDEX IF NOT EXISTS idx_filter_rules_user ON filter_rules(user_id);
+
+                CREATE TABLE IF NOT EXISTS post_tags (
+                    user_id INTEGER NOT NULL,
+                    post_id INTEGER NOT NULL,
+                    tag TEXT NOT NULL,
+                    PRIMARY KEY (user_id, post_id, tag),
+                    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+                );
+
+                CREATE INDEX IF NOT EXISTS idx_post_tags_tag ON post_tags(user_id, tag);
+                CREATE INDEX IF NOT EXISTS idx_post_tags_post ON post_tags(post_id);
+            `),
+			addColumns("post_states", []columnDef{
+				{"is_hidden", "BOOLEAN NOT NULL DEFAULT 0"},
+			}),
+		),
+	},
+	{
+		version: 10,
+		name:    "add full-text extraction queue",
+		// Finished extractions are removed from the queue; only pending and
+		// failed ones are kept
+		up: steps(
+			addColumns("feeds", []columnDef{
+				{"fetch_full_text", "BOOLEAN NOT NULL DEFAULT 0"},
+			}),
+			addColumns("posts", []columnDef{
+				{"full_content", "TEXT"},
+			}),
+			execSQL(`
+                CREATE TABLE IF NOT EXISTS content_extractions (
+                    post_id INTEGER PRIMARY KEY,
+                    status TEXT NOT NULL DEFAULT 'pending',
+                    attempts INTEGER NOT NULL DEFAULT 0,
+                    next_attempt_at DATETIME,
+                    last_error TEXT,
+                    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+                );
+
+                CREATE INDEX IF NOT EXISTS idx_content_extractions_due
+                    ON content_extractions(next_attempt_at) WHERE status = 'pending';
+            `),
+		),
+	},
+	{
+		version: 11,
+		name:    "add post excerpts and searchable text",
+		// Posts with no excerpt yet are sanitized at startup. The search
+		// triggers are dropped so the index is rebuilt over the plain text.
+		up: steps(
+			addColumns("posts", []columnDef{
+				{"excerpt", "TEXT"},
+				{"text_content", "TEXT"},
+			}),
+			execSQL(`
+                DROP TRIGGER IF EXISTS posts_fts_insert;
+                DROP TRIGGER IF EXISTS posts_fts_delete;
+                DROP TRIGGER IF EXISTS posts_fts_update;
+            `),
+		),
+	},
+	{
+		version: 12,
+		name:    "add lead image sources",
+		// Sources are only known for posts fetched from now on
+		up: steps(
+			addColumns("feeds", []columnDef{
+				{"fetch_page_image", "BOOLEAN NOT NULL DEFAULT 0"},
+			}),
+			addColumns("posts", []columnDef{
+				{"image_source", "TEXT NOT NULL DEFAULT ''"},
+			}),
+		),
+	},
+	{
+		version: 13,
+		name:    "add story clusters",
+		// A post's cluster is the ID of the first post of its story, or NULL
+		// for a story with a single post. Existing posts are given their
+		// signatures at startup; their links are not resolved.
+		up: steps(
+			addColumns("posts", []columnDef{
+				{"url_key", "TEXT"},
+				{"url_resolved", "BOOLEAN NOT NULL DEFAULT 0"},
+				{"title_simhash", "INTEGER"},
+				{"cluster_id", "INTEGER"},
+			}),
+			execSQL(`
+                UPDATE posts SET url_resolved = 1;
+
+                CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts(created_at);
+                CREATE INDEX IF NOT EXISTS idx_posts_url_key ON posts(url_key);
+                CREATE INDEX IF NOT EXISTS idx_posts_cluster ON posts(cluster_id)
+                    WHERE cluster_id IS NOT NULL;
+            `),
+		),
+	},
+	{
+		version: 14,
+		name:    "index feed changes",
+		// The poller picks up changed feeds by their updated_at
+		up: execSQL(`
+            CREATE INDEX IF NOT EXISTS idx_feeds_updated_at ON feeds(updated_at);
+        `),
+	},
+	{
+		version: 15,
+		name:    "track feed schedule changes",
+		// updated_at is bumped by every fetch, so the poller gets a column
+		// of its own: changed_at moves only when a feed is created, edited
+		// or subscribed to
+		up: steps(
+			addColumns("feeds", []columnDef{
+				{"changed_at", "DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+			}),
+			execSQL(`
+                UPDATE feeds SET changed_at = updated_at;
+                DROP INDEX IF EXISTS idx_feeds_updated_at;
+                CREATE INDEX IF NOT EXISTS idx_feeds_changed_at ON feeds(changed_at);
+            `),
+		),
+	},
+}
+
+// Migrate applies every pending migration in order, each in its own
+// transaction. It refuses to run against a database with migrations newer
+// than the latest one this binary knows about.
+func (db *DB) Migrate() error {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return err
+	}
+
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	latest := migrations[len(migrations)-1].version
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("%w: database is at version %d, binary supports up to %d",
+				ErrSchemaTooNew, version, latest)
+		}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := db.applyMigration(m); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d: %s", m.version, m.name)
+	}
+
+	return nil
+}
+
+// MigrationStatus lists every known migration and whether it has been
+// applied, plus any applied migrations this binary does not know about
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.version] = true
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if at, ok := applied[m.version]; ok {
+			status.Applied = true
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, at := range applied {
+		if !known[version] {
+			at := at
+			statuses = append(statuses, MigrationStatus{
+				Version: version, Name: "(unknown)", Applied: true, AppliedAt: &at,
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+func (db *DB) ensureMigrationsTable() error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) appliedMigrations() (map[int]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+func (db *DB) applyMigration(m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name,
+	); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+	}
+
+	return tx.Commit()
+}
+
+// execSQL returns a migration step that runs the given statements
+func execSQL(stmts string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(stmts)
+		return err
+	}
+}
+
+// steps returns a migration step that runs the given steps in order
+func steps(fns ...func(tx *sql.Tx) error) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, fn := range fns {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+type columnDef struct {
+	name       string
+	definition string
+}
+
+// addColumns returns a migration step that adds any of the given columns
+// that the table does not already have
+func addColumns(table string, columns []columnDef) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		existing, err := tableColumns(tx, table)
+		if err != nil {
+			return err
+		}
+		for _, c := range columns {
+			if existing[c.name] {
+				continue
+			}
+			stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, c.name, c.definition)
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// tableColumns returns the set of column names in a table
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue interface{}
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// baselineSchema is the schema databases had before migrations existed.
+// Some had already gained the etag column.
+const baselineSchema = `
+CREATE TABLE feeds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    url TEXT NOT NULL UNIQUE,
+    category TEXT,
+    site_url TEXT,
+    description TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT 1,
+    last_fetched_at DATETIME,
+    error_count INTEGER DEFAULT 0,
+    last_error TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    etag TEXT
+);
+
+CREATE INDEX idx_feeds_is_active ON feeds(is_active);
+CREATE INDEX idx_feeds_last_fetched ON feeds(last_fetched_at);
+
+CREATE TABLE posts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    feed_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    link TEXT NOT NULL,
+    description TEXT,
+    content TEXT,
+    author TEXT,
+    published_at DATETIME,
+    image_url TEXT,
+    guid TEXT NOT NULL,
+    is_read BOOLEAN NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE,
+    UNIQUE(feed_id, guid)
+);
+
+CREATE INDEX idx_posts_feed_id ON posts(feed_id);
+CREATE INDEX idx_posts_published_at ON posts(published_at DESC);
+CREATE INDEX idx_posts_is_read ON posts(is_read);
+CREATE UNIQUE INDEX idx_posts_feed_guid ON posts(feed_id, guid);
+
+INSERT INTO feeds (name, url, category, etag) VALUES ('Old Feed', 'https://example.com/feed.xml', 'News', '"abc"');
+INSERT INTO posts (feed_id, title, link, description, content, author, published_at, image_url, guid, is_read)
+VALUES (1, 'Read post', 'https://example.com/1', '<p>Old <b>body</b></p>', '', '', '2024-05-01 12:00:00+02:00', '', 'guid-1', 1),
+       (1, 'Unread post', 'https://example.com/2', '', '', '', NULL, '', 'guid-2', 0);
+`
+
+func TestMigrateFromBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rssy.db")
+	db, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("migrating the baseline database: %v", err)
+	}
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Errorf("%d migrations reported, want %d", len(statuses), len(migrations))
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("migration %d (%s) was not applied", status.Version, status.Name)
+		}
+	}
+
+	var published string
+	if err := db.QueryRow("SELECT published_at || '' FROM posts WHERE guid = 'guid-1'").Scan(&published); err != nil {
+		t.Fatal(err)
+	}
+	if published != "2024-05-01 10:00:00+00:00" {
+		t.Errorf("published_at = %q, want it normalised to UTC", published)
+	}
+
+	// The first account adopts the single-user data
+	user, err := db.CreateUser("admin", "not-a-real-hash", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeds, err := db.GetUserFeeds(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 1 || feeds[0].Name != "Old Feed" || feeds[0].ETag == nil || *feeds[0].ETag != `"abc"` {
+		t.Fatalf("feeds after migration = %+v", feeds)
+	}
+
+	page, err := db.ListPosts(models.PostFilter{UserID: user.ID, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := map[string]bool{}
+	for _, post := range page.Posts {
+		read[post.Title] = post.IsRead
+	}
+	if len(read) != 2 || !read["Read post"] || read["Unread post"] {
+		t.Errorf("read state after migration = %v", read)
+	}
+
+	// Running again changes nothing
+	if err := db.Migrate(); err != nil {
+		t.Errorf("second migration run: %v", err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db, _, _ := newTestDB(t)
+
+	latest := migrations[len(migrations)-1].version
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')", latest+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Migrate = %v, want %v", err, ErrSchemaTooNew)
+	}
+}
@@ -2,122 +2,444 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/justanotherspy/rssy/internal/models"
 )
 
-// GetAllPosts retrieves all posts with pagination
-func (db *DB) GetAllPosts(limit, offset int) ([]models.PostWithFeed, error) {
-	query := `
-        SELECT p.id, p.feed_id, p.title, p.link, p.description, p.content,
-               p.author, p.published_at, p.image_url, p.guid, p.is_read,
-               p.created_at, p.updated_at, f.name as feed_name
+// postColumns is the column list shared by every query that returns posts
+// joined with their feed name and a user's state, in the order expected by
+// scanPostWithFeed. It expects the joins in postSource.
+const postColumns = `p.id, p.feed_id, p.title, p.link, p.description, p.content,
+               p.full_content, COALESCE(p.excerpt, ''), p.author, p.published_at, p.image_url,
+               p.image_source, p.guid, COALESCE(p.cluster_id, p.id), COALESCE(ps.is_read, 0),
+               COALESCE(ps.is_starred, 0), ps.starred_at, p.created_at, p.updated_at,
+               f.name as feed_name`
+
+// postSource joins posts to their feed and to one user's read and star
+// state. It takes the user ID as its parameter.
+const postSource = `
         FROM posts p
         JOIN feeds f ON p.feed_id = f.id
-        ORDER BY p.published_at DESC
+        LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = ?`
+
+// postVisible limits posts to those a user can see: every post of the feeds
+// they subscribe to, plus anything they starred, except posts their filter
+// rules dropped. It takes the user ID as its parameter.
+const postVisible = `(COALESCE(ps.is_hidden, 0) = 0 AND
+        (p.feed_id IN (SELECT feed_id FROM subscriptions WHERE user_id = ?) OR ps.is_starred = 1))`
+
+func scanPostWithFeed(row rowScanner, post *models.PostWithFeed, extra ...interface{}) error {
+	dest := []interface{}{
+		&post.ID, &post.FeedID, &post.Title, &post.Link, &post.Description,
+		&post.Content, &post.FullContent, &post.Excerpt, &post.Author, &post.PublishedAt,
+		&post.ImageURL, &post.ImageSource, &post.GUID, &post.ClusterID, &post.IsRead, &post.IsStarred, &post.StarredAt,
+		&post.CreatedAt, &post.UpdatedAt, &post.FeedName,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// ListPosts retrieves a page of the user's posts matching the filter, in
+// the order it specifies. Every filter is applied in SQL. When
+// filter.Cursor is set the page starts after the post it identifies
+// (keyset pagination) and Offset is ignored; the returned page carries the
+// cursor for the page after it.
+func (db *DB) ListPosts(filter models.PostFilter) (*models.PostPage, error) {
+	conds, args := postFilterConditions(filter)
+	if filter.Sort == models.SortStarred {
+		conds = append(conds, "ps.is_starred = 1")
+	}
+	if filter.Collapse {
+		// A story is listed as the first of its posts that matches
+		conds = append(conds, `p.id IN (
+            SELECT MIN(p.id)`+postSource+`
+            WHERE `+strings.Join(conds, " AND ")+`
+            GROUP BY COALESCE(p.cluster_id, p.id))`)
+		args = append(args, append([]interface{}{filter.UserID}, args...)...)
+	}
+
+	offset := filter.Offset
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor, filter.Sort)
+		if err != nil {
+			return nil, err
+		}
+		cond, cursorArgs := cursorCondition(cursor, filter.Sort)
+		conds = append(conds, cond)
+		args = append(args, cursorArgs...)
+		offset = 0
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	query := `
+        SELECT ` + postColumns + `, ` + sortKeyColumn(filter.Sort) + ` as sort_key` +
+		postSource + `
+        WHERE ` + strings.Join(conds, " AND ") + `
+        ORDER BY ` + postOrderClause(filter.Sort) + `
         LIMIT ? OFFSET ?
     `
+	args = append([]interface{}{filter.UserID}, args...)
+	args = append(args, filter.Limit+1, offset)
 
-	rows, err := db.Query(query, limit, offset)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	posts := []models.PostWithFeed{}
+	page := &models.PostPage{Posts: []models.PostWithFeed{}}
+	var lastKey sql.NullString
 	for rows.Next() {
 		var post models.PostWithFeed
-		err := rows.Scan(
-			&post.ID, &post.FeedID, &post.Title, &post.Link, &post.Description,
-			&post.Content, &post.Author, &post.PublishedAt, &post.ImageURL,
-			&post.GUID, &post.IsRead, &post.CreatedAt, &post.UpdatedAt,
-			&post.FeedName,
-		)
-		if err != nil {
+		var key sql.NullString
+		if err := scanPostWithFeed(rows, &post, &key); err != nil {
 			return nil, err
 		}
-		posts = append(posts, post)
+		if len(page.Posts) == filter.Limit {
+			page.HasMore = true
+			break
+		}
+		page.Posts = append(page.Posts, post)
+		lastKey = key
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return posts, nil
+	rows.Close()
+
+	posts := make([]*models.Post, len(page.Posts))
+	for i := range page.Posts {
+		posts[i] = &page.Posts[i].Post
+	}
+	if err := db.loadPostTags(filter.UserID, posts); err != nil {
+		return nil, err
+	}
+	if filter.Collapse {
+		if err := db.loadStoryCopies(filter.UserID, page.Posts); err != nil {
+			return nil, err
+		}
+	}
+
+	if page.HasMore {
+		cursor := postCursor{Sort: normalizeSort(filter.Sort), ID: page.Posts[len(page.Posts)-1].ID}
+		if lastKey.Valid {
+			cursor.Key = &lastKey.String
+		}
+		page.NextCursor = encodeCursor(cursor)
+	}
+
+	return page, nil
 }
 
-// GetPostsByFeedID retrieves posts for a specific feed
-func (db *DB) GetPostsByFeedID(feedID int64, limit, offset int) ([]models.Post, error) {
-	query := `
-        SELECT id, feed_id, title, link, description, content, author,
-               published_at, image_url, guid, is_read, created_at, updated_at
-        FROM posts
-        WHERE feed_id = ?
-        ORDER BY published_at DESC
-        LIMIT ? OFFSET ?
-    `
+// postFilterConditions builds the WHERE conditions for a post filter,
+// starting with the user's visibility. It expects the joins in postSource.
+func postFilterConditions(filter models.PostFilter) ([]string, []interface{}) {
+	conds := []string{postVisible}
+	args := []interface{}{filter.UserID}
+
+	if len(filter.FeedIDs) > 0 {
+		placeholders := make([]string, len(filter.FeedIDs))
+		for i, id := range filter.FeedIDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		conds = append(conds, "p.feed_id IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.Category != "" {
+		conds = append(conds, "f.category = ?")
+		args = append(args, filter.Category)
+	}
+	if filter.IsRead != nil {
+		conds = append(conds, "COALESCE(ps.is_read, 0) = ?")
+		args = append(args, *filter.IsRead)
+	}
+	if filter.IsStarred != nil {
+		conds = append(conds, "COALESCE(ps.is_starred, 0) = ?")
+		args = append(args, *filter.IsStarred)
+	}
+	if filter.Author != "" {
+		conds = append(conds, `p.author LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Author)+"%")
+	}
+	if filter.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM post_tags pt WHERE pt.post_id = p.id AND pt.user_id = ? AND pt.tag = ?)")
+		args = append(args, filter.UserID, filter.Tag)
+	}
+	if filter.PublishedAfter != nil {
+		conds = append(conds, "p.published_at >= ?")
+		args = append(args, filter.PublishedAfter.UTC())
+	}
+	if filter.PublishedBefore != nil {
+		conds = append(conds, "p.published_at < ?")
+		args = append(args, filter.PublishedBefore.UTC())
+	}
+	if filter.OlderThan != nil {
+		conds = append(conds, "COALESCE(p.published_at, p.created_at) < ?")
+		args = append(args, filter.OlderThan.UTC())
+	}
 
-	rows, err := db.Query(query, feedID, limit, offset)
+	return conds, args
+}
+
+// loadPostTags fills in the user's tags on the posts
+func (db *DB) loadPostTags(userID int64, posts []*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*models.Post, len(posts))
+	placeholders := make([]string, len(posts))
+	args := []interface{}{userID}
+	for i, post := range posts {
+		byID[post.ID] = post
+		placeholders[i] = "?"
+		args = append(args, post.ID)
+	}
+
+	rows, err := db.Query(`
+        SELECT post_id, tag FROM post_tags
+        WHERE user_id = ? AND post_id IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY tag ASC
+    `, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	posts := []models.Post{}
 	for rows.Next() {
-		var post models.Post
-		err := rows.Scan(
-			&post.ID, &post.FeedID, &post.Title, &post.Link, &post.Description,
-			&post.Content, &post.Author, &post.PublishedAt, &post.ImageURL,
-			&post.GUID, &post.IsRead, &post.CreatedAt, &post.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
+		var postID int64
+		var tag string
+		if err := rows.Scan(&postID, &tag); err != nil {
+			return err
 		}
-		posts = append(posts, post)
+		if post, ok := byID[postID]; ok {
+			post.Tags = append(post.Tags, tag)
+		}
+	}
+
+	return rows.Err()
+}
+
+// postOrderClause maps a sort name to its ORDER BY expression. The id
+// tie-breaker keeps pages stable when timestamps collide.
+func postOrderClause(sort string) string {
+	switch sort {
+	case models.SortOldest:
+		return "p.published_at ASC, p.id ASC"
+	case models.SortFetched:
+		return "p.created_at DESC, p.id DESC"
+	case models.SortStarred:
+		return "ps.starred_at DESC, p.id DESC"
+	default:
+		return "p.published_at DESC, p.id DESC"
+	}
+}
+
+// escapeLike escapes LIKE wildcards so the value matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// GetAllPosts retrieves a user's posts with pagination
+func (db *DB) GetAllPosts(userID int64, limit, offset int) ([]models.PostWithFeed, error) {
+	page, err := db.ListPosts(models.PostFilter{UserID: userID, Limit: limit, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+	return page.Posts, nil
+}
+
+// GetPostsByFeedID retrieves a user's posts for a specific feed
+func (db *DB) GetPostsByFeedID(userID, feedID int64, limit, offset int) ([]models.Post, error) {
+	page, err := db.ListPosts(models.PostFilter{
+		UserID:  userID,
+		FeedIDs: []int64{feedID},
+		Limit:   limit,
+		Offset:  offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	posts := make([]models.Post, len(page.Posts))
+	for i, post := range page.Posts {
+		posts[i] = post.Post
 	}
 
 	return posts, nil
 }
 
-// CreatePost creates a new post (used by feed fetcher)
+// CreatePost creates a new post (used by feed fetcher), filling in its ID
+// and timestamps
 func (db *DB) CreatePost(post *models.Post) error {
 	query := `
-        INSERT INTO posts (feed_id, title, link, description, content, author,
-                          published_at, image_url, guid)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        INSERT INTO posts (feed_id, title, link, description, content, excerpt,
+                          text_content, author, published_at, image_url, image_source, guid,
+                          url_key, title_simhash)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        RETURNING id, created_at, updated_at
     `
 
-	result, err := db.Exec(
-		query, post.FeedID, post.Title, post.Link, post.Description,
-		post.Content, post.Author, post.PublishedAt, post.ImageURL, post.GUID,
-	)
+	// Store timestamps in UTC so they compare and sort correctly as text
+	var publishedAt *time.Time
+	if post.PublishedAt != nil {
+		utc := post.PublishedAt.UTC()
+		publishedAt = &utc
+		post.PublishedAt = publishedAt
+	}
+
+	err := db.QueryRow(
+		query, post.FeedID, post.Title, post.Link, post.Description, post.Content,
+		post.Excerpt, post.TextContent, post.Author, publishedAt, post.ImageURL, post.ImageSource,
+		post.GUID, nullIfEmpty(post.URLKey), titleHashValue(post.TitleHash),
+	).Scan(&post.ID, &post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		return translateError(err)
+	}
+	post.ClusterID = post.ID
+
+	return nil
+}
+
+// MarkPostAsRead marks a post read or unread for a user
+func (db *DB) MarkPostAsRead(userID, id int64, isRead bool) error {
+	result, err := db.Exec(`
+        INSERT INTO post_states (user_id, post_id, is_read)
+        SELECT ?, p.id, ?`+postSource+`
+        WHERE p.id = ? AND `+postVisible+`
+        ON CONFLICT (user_id, post_id) DO UPDATE SET is_read = excluded.is_read
+    `, userID, isRead, userID, id, userID)
 	if err != nil {
 		return err
 	}
+	return requireRows(result)
+}
 
-	id, err := result.LastInsertId()
+// StarPost stars or unstars a post for a user. Starring records when it
+// happened and re-starring keeps the original time. Posts starred by anyone
+// are never deleted by DeleteAllPosts, retention pruning or unsubscribing.
+func (db *DB) StarPost(userID, id int64, starred bool) error {
+	result, err := db.Exec(`
+        INSERT INTO post_states (user_id, post_id, is_starred, starred_at)
+        SELECT ?, p.id, ?, CASE WHEN ? THEN CURRENT_TIMESTAMP END`+postSource+`
+        WHERE p.id = ? AND `+postVisible+`
+        ON CONFLICT (user_id, post_id) DO UPDATE SET
+            is_starred = excluded.is_starred,
+            starred_at = CASE
+                WHEN NOT excluded.is_starred THEN NULL
+                WHEN post_states.is_starred THEN post_states.starred_at
+                ELSE excluded.starred_at
+            END
+    `, userID, starred, starred, userID, id, userID)
 	if err != nil {
 		return err
 	}
+	return requireRows(result)
+}
 
-	post.ID = id
-	return nil
+// maxBatchSize bounds the number of bound parameters in one statement
+const maxBatchSize = 500
+
+// setReadState upserts the user's read state for every visible post
+// matching conds whose state differs, returning how many posts changed
+func setReadState(tx *sql.Tx, userID int64, conds []string, args []interface{}, isRead bool) (int64, error) {
+	conds = append(conds, "COALESCE(ps.is_read, 0) <> ?")
+	args = append(args, isRead)
+
+	query := `
+        INSERT INTO post_states (user_id, post_id, is_read)
+        SELECT ?, p.id, ?` + postSource + `
+        WHERE ` + strings.Join(conds, " AND ") + `
+        ON CONFLICT (user_id, post_id) DO UPDATE SET is_read = excluded.is_read
+    `
+	result, err := tx.Exec(query, append([]interface{}{userID, isRead, userID}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-// MarkPostAsRead marks a post as read
-func (db *DB) MarkPostAsRead(id int64, isRead bool) error {
-	_, err := db.Exec("UPDATE posts SET is_read = ? WHERE id = ?", isRead, id)
-	return err
+// MarkPostsReadByFilter sets the user's read state of every post matching
+// the filter in a single transaction and returns how many posts changed.
+// Pagination, sort and cursor fields of the filter are ignored.
+func (db *DB) MarkPostsReadByFilter(filter models.PostFilter, isRead bool) (int64, error) {
+	conds, args := postFilterConditions(filter)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	updated, err := setReadState(tx, filter.UserID, conds, args, isRead)
+	if err != nil {
+		return 0, err
+	}
+
+	return updated, tx.Commit()
 }
 
-// DeleteAllPosts deletes all posts (for reset functionality)
+// MarkPostsRead sets the user's read state of the given posts in a single
+// transaction and returns how many posts changed
+func (db *DB) MarkPostsRead(userID int64, ids []int64, isRead bool) (int64, error) {
+	return db.markPostsRead(userID, ids, isRead, "p.id IN (%s)")
+}
+
+// MarkStoriesRead is MarkPostsRead for every copy of the given posts'
+// stories the user can see
+func (db *DB) MarkStoriesRead(userID int64, ids []int64, isRead bool) (int64, error) {
+	return db.markPostsRead(userID, ids, isRead,
+		"COALESCE(p.cluster_id, p.id) IN (SELECT COALESCE(cluster_id, id) FROM posts WHERE id IN (%s))")
+}
+
+// markPostsRead sets the read state of the posts selected by idCond, a
+// condition with a %s for the list of IDs
+func (db *DB) markPostsRead(userID int64, ids []int64, isRead bool, idCond string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var updated int64
+	for start := 0; start < len(ids); start += maxBatchSize {
+		chunk := ids[start:min(start+maxBatchSize, len(ids))]
+
+		placeholders := make([]string, len(chunk))
+		args := []interface{}{userID}
+		for i, id := range chunk {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		conds := []string{postVisible, fmt.Sprintf(idCond, strings.Join(placeholders, ", "))}
+
+		n, err := setReadState(tx, userID, conds, args, isRead)
+		if err != nil {
+			return 0, err
+		}
+		updated += n
+	}
+
+	return updated, tx.Commit()
+}
+
+// DeleteAllPosts deletes every post nobody has starred (for reset
+// functionality). It affects all users.
 func (db *DB) DeleteAllPosts() error {
-	_, err := db.Exec("DELETE FROM posts")
+	_, err := db.Exec("DELETE FROM posts WHERE id NOT IN (SELECT post_id FROM post_states WHERE is_starred = 1)")
 	return err
 }
 
-// GetPostByGUID checks if a post exists by GUID
+// GetPostByGUID checks if a post exists by GUID. The returned post carries
+// no read or star state.
 func (db *DB) GetPostByGUID(feedID int64, guid string) (*models.Post, error) {
 	query := `
-        SELECT id, feed_id, title, link, description, content, author,
-               published_at, image_url, guid, is_read, created_at, updated_at
+        SELECT id, feed_id, title, link, description, content, full_content,
+               COALESCE(excerpt, ''), author, published_at, image_url, image_source,
+               guid, created_at, updated_at
         FROM posts
         WHERE feed_id = ? AND guid = ?
     `
@@ -125,8 +447,8 @@ func (db *DB) GetPostByGUID(feedID int64, guid string) (*models.Post, error) {
 	var post models.Post
 	err := db.QueryRow(query, feedID, guid).Scan(
 		&post.ID, &post.FeedID, &post.Title, &post.Link, &post.Description,
-		&post.Content, &post.Author, &post.PublishedAt, &post.ImageURL,
-		&post.GUID, &post.IsRead, &post.CreatedAt, &post.UpdatedAt,
+		&post.Content, &post.FullContent, &post.Excerpt, &post.Author, &post.PublishedAt,
+		&post.ImageURL, &post.ImageSource, &post.GUID, &post.CreatedAt, &post.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -138,3 +460,42 @@ func (db *DB) GetPostByGUID(feedID int64, guid string) (*models.Post, error) {
 
 	return &post, nil
 }
+
+// GetUnsanitizedPosts retrieves up to limit posts stored before content
+// was sanitized, which have no excerpt. Only the fields sanitization
+// needs are filled in.
+func (db *DB) GetUnsanitizedPosts(limit int) ([]models.Post, error) {
+	rows, err := db.Query(`
+        SELECT id, link, description, content, full_content
+        FROM posts
+        WHERE excerpt IS NULL
+        ORDER BY id ASC
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []models.Post{}
+	for rows.Next() {
+		var post models.Post
+		if err := rows.Scan(&post.ID, &post.Link, &post.Description, &post.Content, &post.FullContent); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+// UpdateSanitizedPost saves a post's sanitized content, excerpt and
+// searchable text
+func (db *DB) UpdateSanitizedPost(post *models.Post) error {
+	_, err := db.Exec(`
+        UPDATE posts
+        SET description = ?, content = ?, full_content = ?, excerpt = ?, text_content = ?
+        WHERE id = ?
+    `, post.Description, post.Content, post.FullContent, post.Excerpt, post.TextContent, post.ID)
+	return err
+}
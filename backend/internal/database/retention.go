@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// prunablePosts selects the posts a retention pass removes: posts older
+// than their feed's maximum age, or beyond its maximum post count counting
+// newest first (by publication date, or fetch time for undated posts),
+// that nobody has starred and every subscriber of the feed has read. A
+// feed's own limits override the defaults bound to the first two
+// parameters; a limit of 0 or NULL disables it.
+const prunablePosts = `
+    SELECT id FROM (
+        SELECT p.id, p.feed_id, p.created_at,
+               ROW_NUMBER() OVER (
+                   PARTITION BY p.feed_id ORDER BY COALESCE(p.published_at, p.created_at) DESC, p.id DESC
+               ) AS position,
+               COALESCE(f.retention_max_age, ?) AS max_age,
+               COALESCE(f.retention_max_posts, ?) AS max_posts
+        FROM posts p
+        JOIN feeds f ON f.id = p.feed_id
+    ) candidate
+    WHERE (
+        (max_age > 0 AND created_at < datetime('now', '-' || max_age || ' seconds'))
+        OR (max_posts > 0 AND position > max_posts)
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM post_states
+        WHERE post_id = candidate.id AND is_starred = 1
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM subscriptions s
+        LEFT JOIN post_states ps ON ps.user_id = s.user_id AND ps.post_id = candidate.id
+        WHERE s.feed_id = candidate.feed_id AND COALESCE(ps.is_read, 0) = 0
+    )
+`
+
+// PrunePosts deletes read, unstarred posts that fall outside their feed's
+// retention limits and returns how many were removed. maxAge and maxPosts
+// are the defaults for feeds without their own limits; zero disables them.
+// The GUID of every pruned post is kept as a tombstone so it is not
+// fetched again.
+func (db *DB) PrunePosts(maxAge time.Duration, maxPosts int) (int64, error) {
+	args := []interface{}{int64(maxAge / time.Second), maxPosts}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+        INSERT OR IGNORE INTO post_tombstones (feed_id, guid)
+        SELECT feed_id, guid FROM posts WHERE id IN (`+prunablePosts+`)
+    `, args...); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec("DELETE FROM posts WHERE id IN ("+prunablePosts+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, tx.Commit()
+}
+
+// IsPostPruned reports whether a post was removed by retention pruning
+func (db *DB) IsPostPruned(feedID int64, guid string) (bool, error) {
+	var exists int
+	err := db.QueryRow(
+		"SELECT 1 FROM post_tombstones WHERE feed_id = ? AND guid = ?", feedID, guid,
+	).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -5,55 +5,17 @@ import (
 	"log"
 )
 
-const schema = `
-CREATE TABLE IF NOT EXISTS feeds (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT NOT NULL,
-    url TEXT NOT NULL UNIQUE,
-    category TEXT,
-    site_url TEXT,
-    description TEXT,
-    is_active BOOLEAN NOT NULL DEFAULT 1,
-    last_fetched_at DATETIME,
-    error_count INTEGER DEFAULT 0,
-    last_error TEXT,
-    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE INDEX IF NOT EXISTS idx_feeds_is_active ON feeds(is_active);
-CREATE INDEX IF NOT EXISTS idx_feeds_last_fetched ON feeds(last_fetched_at);
-
-CREATE TABLE IF NOT EXISTS posts (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    feed_id INTEGER NOT NULL,
-    title TEXT NOT NULL,
-    link TEXT NOT NULL,
-    description TEXT,
-    content TEXT,
-    author TEXT,
-    published_at DATETIME,
-    image_url TEXT,
-    guid TEXT NOT NULL,
-    is_read BOOLEAN NOT NULL DEFAULT 0,
-    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE,
-    UNIQUE(feed_id, guid)
-);
-
-CREATE INDEX IF NOT EXISTS idx_posts_feed_id ON posts(feed_id);
-CREATE INDEX IF NOT EXISTS idx_posts_published_at ON posts(published_at DESC);
-CREATE INDEX IF NOT EXISTS idx_posts_is_read ON posts(is_read);
-CREATE UNIQUE INDEX IF NOT EXISTS idx_posts_feed_guid ON posts(feed_id, guid);
-`
-
-// InitSchema initializes the database schema
+// InitSchema brings the database schema up to date by applying any pending
+// migrations, then sets up the full-text search index
 func (db *DB) InitSchema() error {
-	_, err := db.Exec(schema)
-	if err != nil {
+	if err := db.Migrate(); err != nil {
 		return fmt.Errorf("failed to initialize schema: %w", err)
 	}
+
+	if err := db.initSearch(); err != nil {
+		return err
+	}
+
 	log.Println("Database schema initialized successfully")
 	return nil
 }
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// ErrSearchUnavailable is returned by SearchPosts when the SQLite library
+// was built without FTS5 (go build -tags sqlite_fts5 enables it)
+var ErrSearchUnavailable = errors.New("full-text search is not available in this build")
+
+// searchSchema creates an external-content FTS5 index over the plain text
+// of posts and the triggers that keep it in sync with every insert, update
+// and delete
+const searchSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+    title, excerpt, text_content, author,
+    content='posts', content_rowid='id',
+    tokenize='porter unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO posts_fts(rowid, title, excerpt, text_content, author)
+    VALUES (new.id, new.title, new.excerpt, new.text_content, new.author);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+    INSERT INTO posts_fts(posts_fts, rowid, title, excerpt, text_content, author)
+    VALUES ('delete', old.id, old.title, old.excerpt, old.text_content, old.author);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, excerpt, text_content, author ON posts BEGIN
+    INSERT INTO posts_fts(posts_fts, rowid, title, excerpt, text_content, author)
+    VALUES ('delete', old.id, old.title, old.excerpt, old.text_content, old.author);
+    INSERT INTO posts_fts(rowid, title, excerpt, text_content, author)
+    VALUES (new.id, new.title, new.excerpt, new.text_content, new.author);
+END;
+`
+
+var searchTriggers = []string{"posts_fts_insert", "posts_fts_delete", "posts_fts_update"}
+
+// initSearch sets up the full-text index when FTS5 is compiled in. Without
+// FTS5 the sync triggers are dropped, since they would make every write to
+// posts fail; the index is rebuilt the next time an FTS5 build starts.
+func (db *DB) initSearch() error {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return fmt.Errorf("failed to detect FTS5 support: %w", err)
+	}
+
+	if !enabled {
+		for _, trigger := range searchTriggers {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+				return fmt.Errorf("failed to drop trigger %s: %w", trigger, err)
+			}
+		}
+		log.Println("FTS5 not available; full-text search disabled (build with -tags sqlite_fts5)")
+		return nil
+	}
+
+	var existing int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?, ?)",
+		searchTriggers[0], searchTriggers[1], searchTriggers[2],
+	).Scan(&existing)
+	if err != nil {
+		return err
+	}
+
+	// Missing triggers mean the index is new, posts changed while it was
+	// not being maintained, or a migration changed its columns; recreate it
+	// so its columns match the current schema
+	if existing < len(searchTriggers) {
+		if _, err := db.Exec("DROP TABLE IF EXISTS posts_fts"); err != nil {
+			return fmt.Errorf("failed to drop search index: %w", err)
+		}
+	}
+
+	if _, err := db.Exec(searchSchema); err != nil {
+		return fmt.Errorf("failed to initialize search index: %w", err)
+	}
+
+	if existing < len(searchTriggers) {
+		log.Println("Rebuilding full-text search index...")
+		if _, err := db.Exec("INSERT INTO posts_fts(posts_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to rebuild search index: %w", err)
+		}
+	}
+
+	db.searchEnabled = true
+	return nil
+}
+
+// SearchPosts runs a full-text query over post titles, excerpts, text and
+// authors, ranked by relevance with title matches weighted highest. The
+// highlighted title and snippet are HTML-escaped apart from their <mark>
+// tags. Only posts visible to the user are searched; feedID and
+// category optionally narrow the results.
+func (db *DB) SearchPosts(userID int64, query string, feedID int64, category string, limit, offset int) (*models.PostSearchResults, error) {
+	if !db.searchEnabled {
+		return nil, ErrSearchUnavailable
+	}
+
+	match := ftsQuery(query)
+	results := &models.PostSearchResults{
+		Results: []models.PostSearchResult{},
+		Limit:   limit,
+		Offset:  offset,
+	}
+	if match == "" {
+		return results, nil
+	}
+
+	where := "posts_fts MATCH ? AND " + postVisible
+	args := []interface{}{userID, match, userID}
+	if feedID > 0 {
+		where += " AND p.feed_id = ?"
+		args = append(args, feedID)
+	}
+	if category != "" {
+		where += " AND f.category = ?"
+		args = append(args, category)
+	}
+
+	countQuery := `
+        SELECT COUNT(*)
+        FROM posts_fts
+        JOIN posts p ON p.id = posts_fts.rowid
+        JOIN feeds f ON f.id = p.feed_id
+        LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = ?
+        WHERE ` + where
+	if err := db.QueryRow(countQuery, args...).Scan(&results.Total); err != nil {
+		return nil, err
+	}
+
+	searchQuery := `
+        SELECT ` + postColumns + `,
+               highlight(posts_fts, 0, ?, ?) as title_highlight,
+               snippet(posts_fts, -1, ?, ?, '…', 24) as snippet,
+               bm25(posts_fts, 10.0, 4.0, 1.0, 2.0) as rank
+        FROM posts_fts
+        JOIN posts p ON p.id = posts_fts.rowid
+        JOIN feeds f ON f.id = p.feed_id
+        LEFT JOIN post_states ps ON ps.post_id = p.id AND ps.user_id = ?
+        WHERE ` + where + `
+        ORDER BY rank
+        LIMIT ? OFFSET ?
+    `
+
+	queryArgs := append([]interface{}{markStart, markEnd, markStart, markEnd}, args...)
+	rows, err := db.Query(searchQuery, append(queryArgs, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result models.PostSearchResult
+		err := scanPostWithFeed(rows, &result.PostWithFeed,
+			&result.TitleHighlight, &result.Snippet, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		result.TitleHighlight = highlightHTML(result.TitleHighlight)
+		result.Snippet = highlightHTML(result.Snippet)
+		results.Results = append(results.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	posts := make([]*models.Post, len(results.Results))
+	for i := range results.Results {
+		posts[i] = &results.Results[i].Post
+	}
+	if err := db.loadPostTags(userID, posts); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Highlight delimiters placed by FTS5, replaced by <mark> tags once the
+// surrounding text is escaped
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// highlightHTML escapes highlighted text for HTML and turns the highlight
+// delimiters into <mark> tags
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, markStart, "<mark>")
+	return strings.ReplaceAll(text, markEnd, "</mark>")
+}
+
+// ftsQuery turns free text into an FTS5 query that matches every word,
+// treating the last word as a prefix. Each word is quoted so user input
+// can never be interpreted as FTS5 query syntax.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	terms[len(terms)-1] += "*"
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"go", `"go"*`},
+		{"golang  generics", `"golang" "generics"*`},
+		{`say "hi"`, `"say" """hi"""*`},
+		{"title:x OR y", `"title:x" "OR" "y"*`},
+	}
+	for _, tt := range tests {
+		if got := ftsQuery(tt.text); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightHTML(t *testing.T) {
+	got := highlightHTML("a <b> & " + markStart + "match" + markEnd)
+	if want := "a &lt;b&gt; &amp; <mark>match</mark>"; got != want {
+		t.Errorf("highlightHTML = %q, want %q", got, want)
+	}
+}
+
+func TestSearchPosts(t *testing.T) {
+	db, user, feed := newTestDB(t)
+	if !db.searchEnabled {
+		if _, err := db.SearchPosts(user.ID, "anything", 0, "", 10, 0); !errors.Is(err, ErrSearchUnavailable) {
+			t.Errorf("SearchPosts without FTS5 = %v, want %v", err, ErrSearchUnavailable)
+		}
+		t.Skip("FTS5 not available; run with -tags sqlite_fts5")
+	}
+
+	other, err := db.CreateFeed(models.CreateFeedRequest{Name: "Other Feed", URL: "https://example.org/feed.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := []struct {
+		feedID      int64
+		title, text string
+	}{
+		{feed.ID, "Generics in <Go>", "Type parameters arrive"},
+		{feed.ID, "Gardening notes", "Tomatoes need generous watering"},
+		{feed.ID, "Cooking", "Nothing relevant here"},
+		{other.ID, "Generics elsewhere", "Not subscribed, so never found"},
+	}
+	for i, p := range posts {
+		post := &models.Post{
+			FeedID:      p.feedID,
+			Title:       p.title,
+			TextContent: p.text,
+			Link:        fmt.Sprintf("https://example.com/%d", i),
+			GUID:        fmt.Sprintf("post-%d", i),
+		}
+		if err := db.CreatePost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := db.SearchPosts(user.ID, "gener", 0, "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 2 || len(results.Results) != 2 {
+		t.Fatalf("search for a prefix found %d (total %d), want 2", len(results.Results), results.Total)
+	}
+	// Title matches are weighted above text matches
+	top := results.Results[0]
+	if top.Title != "Generics in <Go>" {
+		t.Errorf("top result = %q, want the title match", top.Title)
+	}
+	if want := "<mark>Generics</mark> in &lt;Go&gt;"; top.TitleHighlight != want {
+		t.Errorf("title highlight = %q, want %q", top.TitleHighlight, want)
+	}
+
+	results, err = db.SearchPosts(user.ID, `"generics" OR cooking`, 0, "", 10, 0)
+	if err != nil {
+		t.Fatalf("query syntax in user input: %v", err)
+	}
+	if results.Total != 0 {
+		t.Errorf("query syntax in user input matched %d posts, want 0", results.Total)
+	}
+
+	if err := db.Subscribe(user.ID, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	results, err = db.SearchPosts(user.ID, "generics", other.ID, "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 1 || results.Results[0].FeedID != other.ID {
+		t.Errorf("search narrowed to a feed found %+v", results.Results)
+	}
+}
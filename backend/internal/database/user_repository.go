@@ -0,0 +1,271 @@
+package database
+
+import (
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+const userColumns = `id, username, is_admin, created_at, updated_at`
+
+func scanUser(row rowScanner, user *models.User, extra ...interface{}) error {
+	dest := []interface{}{
+		&user.ID, &user.Username, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// CountUsers returns the number of user accounts
+func (db *DB) CountUsers() (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// CreateUser creates an account with an already hashed password. The first
+// account created adopts the data of the single-user installation it
+// replaces: it is subscribed to every existing feed and inherits the old
+// global read and star state.
+func (db *DB) CreateUser(username, passwordHash string, isAdmin bool) (*models.User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&existing); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = scanUser(tx.QueryRow(`
+        INSERT INTO users (username, password_hash, is_admin)
+        VALUES (?, ?, ?)
+        RETURNING `+userColumns,
+		username, passwordHash, isAdmin,
+	), &user)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if existing == 0 {
+		if _, err := tx.Exec(`
+            INSERT INTO subscriptions (user_id, feed_id)
+            SELECT ?, id FROM feeds
+        `, user.ID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`
+            INSERT INTO post_states (user_id, post_id, is_read, is_starred, starred_at)
+            SELECT ?, id, is_read, is_starred, starred_at FROM posts
+            WHERE is_read = 1 OR is_starred = 1
+        `, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, tx.Commit()
+}
+
+// GetUserByID retrieves a user by ID
+func (db *DB) GetUserByID(id int64) (*models.User, error) {
+	var user models.User
+	err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id), &user)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+// GetUserCredentials retrieves a user and their password hash by username,
+// ignoring case
+func (db *DB) GetUserCredentials(username string) (*models.User, string, error) {
+	var user models.User
+	var passwordHash string
+	err := scanUser(db.QueryRow(
+		"SELECT "+userColumns+", password_hash FROM users WHERE username = ?", username,
+	), &user, &passwordHash)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	return &user, passwordHash, nil
+}
+
+// GetPasswordHash retrieves a user's password hash
+func (db *DB) GetPasswordHash(userID int64) (string, error) {
+	var passwordHash string
+	err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash)
+	if err != nil {
+		return "", translateError(err)
+	}
+	return passwordHash, nil
+}
+
+// GetAllUsers retrieves every user account
+func (db *DB) GetAllUsers() ([]models.User, error) {
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY username ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// UpdateUserPassword replaces a user's password hash and revokes all of
+// their sessions except keepTokenID, so other logged-in devices must sign in
+// again. API tokens are left alone.
+func (db *DB) UpdateUserPassword(userID int64, passwordHash string, keepTokenID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		passwordHash, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := requireRows(result); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"DELETE FROM auth_tokens WHERE user_id = ? AND kind = ? AND id <> ?",
+		userID, models.TokenKindSession, keepTokenID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUser deletes a user along with their tokens, subscriptions and
+// post state. Feeds left without subscribers are kept; retention prunes
+// their posts.
+func (db *DB) DeleteUser(id int64) error {
+	result, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireRows(result)
+}
+
+const tokenColumns = `id, kind, name, expires_at, last_used_at, created_at`
+
+func scanToken(row rowScanner, token *models.AuthToken) error {
+	return row.Scan(
+		&token.ID, &token.Kind, &token.Name,
+		&token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt,
+	)
+}
+
+// CreateToken stores the hash of a newly issued token. expiresAt is nil for
+// tokens that never expire.
+func (db *DB) CreateToken(userID int64, tokenHash, kind, name string, expiresAt *time.Time) (*models.AuthToken, error) {
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	var token models.AuthToken
+	err := scanToken(db.QueryRow(`
+        INSERT INTO auth_tokens (user_id, token_hash, kind, name, expires_at)
+        VALUES (?, ?, ?, ?, ?)
+        RETURNING `+tokenColumns,
+		userID, tokenHash, kind, name, expiresAt,
+	), &token)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &token, nil
+}
+
+// tokenTouchInterval is how out of date a token's last_used_at may get,
+// so that most authenticated requests need no write
+const tokenTouchInterval = time.Minute
+
+// Authenticate looks up the user owning an unexpired token by its hash and
+// records that the token was used, at most once per tokenTouchInterval. It
+// returns ErrNotFound for unknown or expired tokens.
+func (db *DB) Authenticate(tokenHash string) (*models.User, int64, error) {
+	var tokenID, userID int64
+	var lastUsedAt *time.Time
+	err := db.QueryRow(`
+        SELECT id, user_id, last_used_at FROM auth_tokens
+        WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)
+    `, tokenHash, time.Now().UTC()).Scan(&tokenID, &userID, &lastUsedAt)
+	if err != nil {
+		return nil, 0, translateError(err)
+	}
+
+	if lastUsedAt == nil || time.Since(*lastUsedAt) >= tokenTouchInterval {
+		if _, err := db.Exec(
+			"UPDATE auth_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", tokenID,
+		); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return user, tokenID, nil
+}
+
+// GetTokens lists a user's unexpired tokens, newest first
+func (db *DB) GetTokens(userID int64) ([]models.AuthToken, error) {
+	rows, err := db.Query(`
+        SELECT `+tokenColumns+` FROM auth_tokens
+        WHERE user_id = ? AND (expires_at IS NULL OR expires_at > ?)
+        ORDER BY created_at DESC, id DESC
+    `, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.AuthToken{}
+	for rows.Next() {
+		var token models.AuthToken
+		if err := scanToken(rows, &token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// DeleteToken revokes one of a user's tokens
+func (db *DB) DeleteToken(userID, tokenID int64) error {
+	result, err := db.Exec("DELETE FROM auth_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return err
+	}
+	return requireRows(result)
+}
+
+// DeleteExpiredTokens removes tokens whose expiry has passed
+func (db *DB) DeleteExpiredTokens() (int64, error) {
+	result, err := db.Exec("DELETE FROM auth_tokens WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
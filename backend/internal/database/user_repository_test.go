@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func TestUsersAreIsolated(t *testing.T) {
+	db, alice, feed := newTestDB(t)
+	bob, err := db.CreateUser("bob", "not-a-real-hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var posts []*models.Post
+	for i := range 2 {
+		post := &models.Post{
+			FeedID: feed.ID,
+			Title:  fmt.Sprintf("Post %d", i),
+			Link:   fmt.Sprintf("https://example.com/%d", i),
+			GUID:   fmt.Sprintf("post-%d", i),
+		}
+		if err := db.CreatePost(post); err != nil {
+			t.Fatal(err)
+		}
+		posts = append(posts, post)
+	}
+
+	list := func(user *models.User, filter models.PostFilter) []models.PostWithFeed {
+		t.Helper()
+		filter.UserID, filter.Limit = user.ID, 100
+		page, err := db.ListPosts(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page.Posts
+	}
+
+	// Feeds and posts are only visible to their subscribers
+	if feeds, err := db.GetUserFeeds(bob.ID); err != nil || len(feeds) != 0 {
+		t.Errorf("unsubscribed user's feeds = %v, %v", feeds, err)
+	}
+	if got := list(bob, models.PostFilter{}); len(got) != 0 {
+		t.Errorf("unsubscribed user sees %d posts", len(got))
+	}
+	if err := db.MarkPostAsRead(bob.ID, posts[0].ID, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("marking another feed's post read = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := db.Subscribe(bob.ID, feed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read and starred state belongs to each user
+	if err := db.MarkPostAsRead(alice.ID, posts[0].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StarPost(alice.ID, posts[1].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkPostAsRead(bob.ID, posts[1].ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	read, starred := true, true
+	if got := postIDs(list(alice, models.PostFilter{IsRead: &read})); len(got) != 1 || got[0] != posts[0].ID {
+		t.Errorf("alice's read posts = %v, want [%d]", got, posts[0].ID)
+	}
+	if got := postIDs(list(bob, models.PostFilter{IsRead: &read})); len(got) != 1 || got[0] != posts[1].ID {
+		t.Errorf("bob's read posts = %v, want [%d]", got, posts[1].ID)
+	}
+	if got := list(bob, models.PostFilter{IsStarred: &starred}); len(got) != 0 {
+		t.Errorf("bob sees alice's star on %v", postIDs(got))
+	}
+
+	// Unsubscribing leaves the other subscriber's feed in place, and only
+	// starred posts visible
+	if err := db.Unsubscribe(alice.ID, feed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if feeds, err := db.GetUserFeeds(bob.ID); err != nil || len(feeds) != 1 {
+		t.Errorf("other subscriber's feeds after unsubscribing = %v, %v", feeds, err)
+	}
+	if got := postIDs(list(alice, models.PostFilter{})); len(got) != 1 || got[0] != posts[1].ID {
+		t.Errorf("alice sees %v after unsubscribing, want only her starred post %d", got, posts[1].ID)
+	}
+	if got := list(bob, models.PostFilter{}); len(got) != 2 {
+		t.Errorf("bob sees %d posts after alice unsubscribed, want 2", len(got))
+	}
+}
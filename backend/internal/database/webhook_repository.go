@@ -0,0 +1,273 @@
+package database
+
+import (
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+const webhookColumns = `id, url, feed_id, category, is_active, created_at, updated_at`
+
+func scanWebhook(row rowScanner, webhook *models.Webhook, extra ...interface{}) error {
+	dest := []interface{}{
+		&webhook.ID, &webhook.URL, &webhook.FeedID, &webhook.Category,
+		&webhook.IsActive, &webhook.CreatedAt, &webhook.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// GetWebhooks retrieves a user's webhooks
+func (db *DB) GetWebhooks(userID int64) ([]models.Webhook, error) {
+	rows, err := db.Query(
+		"SELECT "+webhookColumns+" FROM webhooks WHERE user_id = ? ORDER BY id ASC", userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetWebhook retrieves one of a user's webhooks
+func (db *DB) GetWebhook(userID, id int64) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := scanWebhook(db.QueryRow(
+		"SELECT "+webhookColumns+" FROM webhooks WHERE id = ? AND user_id = ?", id, userID,
+	), &webhook)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &webhook, nil
+}
+
+// CreateWebhook creates a webhook for a user. The returned webhook carries
+// its secret.
+func (db *DB) CreateWebhook(userID int64, req models.CreateWebhookRequest) (*models.Webhook, error) {
+	var feedID, category interface{}
+	if req.FeedID != nil && *req.FeedID > 0 {
+		feedID = *req.FeedID
+	}
+	if req.Category != nil && *req.Category != "" {
+		category = *req.Category
+	}
+
+	var webhook models.Webhook
+	err := scanWebhook(db.QueryRow(`
+        INSERT INTO webhooks (user_id, url, secret, feed_id, category)
+        VALUES (?, ?, ?, ?, ?)
+        RETURNING `+webhookColumns+`, secret`,
+		userID, req.URL, req.Secret, feedID, category,
+	), &webhook, &webhook.Secret)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &webhook, nil
+}
+
+// UpdateWebhook updates one of a user's webhooks. A new secret is returned
+// on the webhook; otherwise Secret is left empty.
+func (db *DB) UpdateWebhook(userID, id int64, req models.UpdateWebhookRequest) (*models.Webhook, error) {
+	// Build dynamic update query
+	query := "UPDATE webhooks SET updated_at = CURRENT_TIMESTAMP"
+	args := []interface{}{}
+
+	if req.URL != nil {
+		query += ", url = ?"
+		args = append(args, *req.URL)
+	}
+	if req.Secret != nil {
+		query += ", secret = ?"
+		args = append(args, *req.Secret)
+	}
+	if req.FeedID != nil {
+		query += ", feed_id = ?"
+		if *req.FeedID > 0 {
+			args = append(args, *req.FeedID)
+		} else {
+			args = append(args, nil)
+		}
+	}
+	if req.Category != nil {
+		query += ", category = ?"
+		args = append(args, nullIfEmpty(*req.Category))
+	}
+	if req.IsActive != nil {
+		query += ", is_active = ?"
+		args = append(args, *req.IsActive)
+	}
+
+	query += " WHERE id = ? AND user_id = ? RETURNING " + webhookColumns
+	args = append(args, id, userID)
+
+	var webhook models.Webhook
+	if err := scanWebhook(db.QueryRow(query, args...), &webhook); err != nil {
+		return nil, translateError(err)
+	}
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	}
+
+	return &webhook, nil
+}
+
+// DeleteWebhook deletes one of a user's webhooks along with its deliveries
+func (db *DB) DeleteWebhook(userID, id int64) error {
+	result, err := db.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRows(result)
+}
+
+// EnqueueWebhookDeliveries queues a delivery of the payload for a new post
+// to every active webhook that matches the post's feed: its owner must
+// subscribe to the feed and not have dropped the post with a filter rule,
+// and any feed or category filter must match. It returns how many
+// deliveries were queued.
+func (db *DB) EnqueueWebhookDeliveries(feedID, postID int64, event string, payload []byte) (int64, error) {
+	result, err := db.Exec(`
+        INSERT INTO webhook_deliveries (webhook_id, post_id, event, payload, next_attempt_at)
+        SELECT w.id, ?, ?, ?, ?
+        FROM webhooks w
+        JOIN subscriptions s ON s.user_id = w.user_id AND s.feed_id = ?
+        JOIN feeds f ON f.id = s.feed_id
+        WHERE w.is_active = 1
+          AND (w.feed_id IS NULL OR w.feed_id = f.id)
+          AND (w.category IS NULL OR w.category = f.category)
+          AND NOT EXISTS (
+              SELECT 1 FROM post_states ps
+              WHERE ps.user_id = w.user_id AND ps.post_id = ? AND ps.is_hidden = 1
+          )
+    `, postID, event, string(payload), time.Now().UTC(), feedID, postID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetDueDeliveries retrieves up to limit pending deliveries of active
+// webhooks whose next attempt is due, oldest first
+func (db *DB) GetDueDeliveries(limit int) ([]models.PendingDelivery, error) {
+	rows, err := db.Query(`
+        SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.id = d.webhook_id
+        WHERE d.status = ? AND d.next_attempt_at <= ? AND w.is_active = 1
+        ORDER BY d.next_attempt_at ASC, d.id ASC
+        LIMIT ?
+    `, models.DeliveryPending, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.PendingDelivery{}
+	for rows.Next() {
+		var d models.PendingDelivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RecordDeliverySuccess marks a delivery as delivered
+func (db *DB) RecordDeliverySuccess(id int64, statusCode int) error {
+	_, err := db.Exec(`
+        UPDATE webhook_deliveries
+        SET status = ?, attempts = attempts + 1, last_status_code = ?, last_error = NULL,
+            next_attempt_at = NULL, delivered_at = ?
+        WHERE id = ?
+    `, models.DeliveryDelivered, statusCode, time.Now().UTC(), id)
+	return err
+}
+
+// RecordDeliveryFailure records a failed attempt. The delivery is retried
+// at retryAt, or marked failed for good when retryAt is nil. statusCode is
+// 0 when no response was received.
+func (db *DB) RecordDeliveryFailure(id int64, statusCode int, message string, retryAt *time.Time) error {
+	status := models.DeliveryPending
+	if retryAt == nil {
+		status = models.DeliveryFailed
+	} else {
+		utc := retryAt.UTC()
+		retryAt = &utc
+	}
+
+	var code interface{}
+	if statusCode > 0 {
+		code = statusCode
+	}
+
+	_, err := db.Exec(`
+        UPDATE webhook_deliveries
+        SET status = ?, attempts = attempts + 1, last_status_code = ?, last_error = ?,
+            next_attempt_at = ?
+        WHERE id = ?
+    `, status, code, message, retryAt, id)
+	return err
+}
+
+// GetDeliveries retrieves the delivery log of one of a user's webhooks,
+// newest first
+func (db *DB) GetDeliveries(userID, webhookID int64, limit, offset int) ([]models.WebhookDelivery, error) {
+	if _, err := db.GetWebhook(userID, webhookID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+        SELECT id, webhook_id, post_id, event, payload, status, attempts, next_attempt_at,
+               last_status_code, last_error, created_at, delivered_at
+        FROM webhook_deliveries
+        WHERE webhook_id = ?
+        ORDER BY id DESC
+        LIMIT ? OFFSET ?
+    `, webhookID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var payload string
+		err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.PostID, &d.Event, &payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload = []byte(payload)
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// DeleteFinishedDeliveries removes delivered and failed deliveries created
+// before the given time
+func (db *DB) DeleteFinishedDeliveries(before time.Time) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM webhook_deliveries WHERE status <> ? AND created_at < ?",
+		models.DeliveryPending, before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	tokenContextKey
+)
+
+// Authenticate is middleware that requires a valid session or API token in
+// an "Authorization: Bearer <token>" header and makes its user available
+// to handlers through currentUser
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rssy"`)
+			h.respondError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		user, tokenID, err := h.db.Authenticate(services.HashToken(token))
+		if errors.Is(err, database.ErrNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rssy", error="invalid_token"`)
+			h.respondError(w, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		if err != nil {
+			h.respondError(w, http.StatusInternalServerError, "Failed to authenticate")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, tokenContextKey, tokenID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAdmin is middleware that only lets administrators through. It
+// must run after Authenticate.
+func (h *Handler) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).IsAdmin {
+			h.respondError(w, http.StatusForbidden, "Administrator access required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentUser returns the user authenticated for the request
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// currentTokenID returns the ID of the token the request was authenticated
+// with
+func currentTokenID(r *http.Request) int64 {
+	id, _ := r.Context().Value(tokenContextKey).(int64)
+	return id
+}
+
+// TokenFromQuery returns middleware for clients that cannot set headers,
+// such as a browser EventSource: on the given paths it moves an
+// access_token query parameter into the Authorization header. Tokens in
+// URLs leak through Referer headers and proxy logs, so other paths never
+// accept one, but it is stripped from every URL all the same. It must run
+// before request logging so the token never appears in logged URLs.
+func TokenFromQuery(paths ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if token := query.Get("access_token"); token != "" {
+				if r.Header.Get("Authorization") == "" && slices.Contains(paths, r.URL.Path) {
+					r.Header.Set("Authorization", "Bearer "+token)
+				}
+				query.Del("access_token")
+				r.URL.RawQuery = query.Encode()
+				r.RequestURI = r.URL.RequestURI()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+// maxUsernameLength bounds the length of account names
+const maxUsernameLength = 64
+
+// dummyPasswordHash is checked against when a login names an unknown user,
+// so the response takes as long as for a wrong password
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := services.HashPassword("rssy-dummy-password")
+	return hash
+})
+
+// Login handles POST /api/auth/login
+// It exchanges a username and password for a session token.
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	var req models.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Username == "" || req.Password == "" {
+		h.respondError(w, http.StatusBadRequest, "Username and password are required")
+		return
+	}
+
+	user, passwordHash, err := h.db.GetUserCredentials(strings.TrimSpace(req.Username))
+	if errors.Is(err, database.ErrNotFound) {
+		services.CheckPassword(req.Password, dummyPasswordHash())
+		h.respondError(w, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+
+	if ok, err := services.CheckPassword(req.Password, passwordHash); err != nil || !ok {
+		h.respondError(w, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+
+	expiresAt := time.Now().Add(h.sessionTTL)
+	token, _, err := h.issueToken(user.ID, models.TokenKindSession, "", &expiresAt)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, models.LoginResponse{
+		Token:     token,
+		ExpiresAt: &expiresAt,
+		User:      user,
+	})
+}
+
+// Logout handles POST /api/auth/logout
+// It revokes the token the request was made with.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := h.db.DeleteToken(currentUser(r).ID, currentTokenID(r)); err != nil {
+		h.respondDBError(w, err, "Token", "Failed to log out")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+// GetCurrentUser handles GET /api/auth/me
+func (h *Handler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	h.respondJSON(w, http.StatusOK, currentUser(r))
+}
+
+// ChangePassword handles PUT /api/auth/password
+// Every other session of the user is revoked; API tokens keep working.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user := currentUser(r)
+	passwordHash, err := h.db.GetPasswordHash(user.ID)
+	if err != nil {
+		h.respondDBError(w, err, "User", "Failed to change password")
+		return
+	}
+	if ok, err := services.CheckPassword(req.CurrentPassword, passwordHash); err != nil || !ok {
+		h.respondError(w, http.StatusBadRequest, "Current password is incorrect")
+		return
+	}
+
+	newHash, err := services.HashPassword(req.NewPassword)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	if err := h.db.UpdateUserPassword(user.ID, newHash, currentTokenID(r)); err != nil {
+		h.respondDBError(w, err, "User", "Failed to change password")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Password changed successfully"})
+}
+
+// GetTokens handles GET /api/auth/tokens
+// It lists the current user's sessions and API tokens, without secrets.
+func (h *Handler) GetTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.db.GetTokens(currentUser(r).ID)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve tokens")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, tokens)
+}
+
+// CreateToken handles POST /api/auth/tokens
+// It issues a named API token that never expires. The secret is only
+// returned in this response.
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		h.respondError(w, http.StatusBadRequest, "Token name is required")
+		return
+	}
+
+	token, stored, err := h.issueToken(currentUser(r).ID, models.TokenKindAPI, req.Name, nil)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	h.respondJSON(w, http.StatusCreated, models.CreatedToken{AuthToken: *stored, Token: token})
+}
+
+// DeleteToken handles DELETE /api/auth/tokens/:id
+func (h *Handler) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	if err := h.db.DeleteToken(currentUser(r).ID, id); err != nil {
+		h.respondDBError(w, err, "Token", "Failed to revoke token")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Token revoked successfully"})
+}
+
+// GetUsers handles GET /api/users (administrators only)
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.db.GetAllUsers()
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, users)
+}
+
+// CreateUser handles POST /api/users (administrators only)
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" || len(req.Username) > maxUsernameLength {
+		h.respondError(w, http.StatusBadRequest,
+			fmt.Sprintf("Username is required and must be at most %d characters", maxUsernameLength))
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passwordHash, err := services.HashPassword(req.Password)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	user, err := h.db.CreateUser(req.Username, passwordHash, req.IsAdmin)
+	if err != nil {
+		h.respondDBError(w, err, "User", "Failed to create user")
+		return
+	}
+
+	h.respondJSON(w, http.StatusCreated, user)
+}
+
+// DeleteUser handles DELETE /api/users/:id (administrators only)
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if id == currentUser(r).ID {
+		h.respondError(w, http.StatusBadRequest, "You cannot delete your own account")
+		return
+	}
+
+	if err := h.db.DeleteUser(id); err != nil {
+		h.respondDBError(w, err, "User", "Failed to delete user")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
+}
+
+// issueToken creates and stores a new token, returning its secret
+func (h *Handler) issueToken(userID int64, kind, name string, expiresAt *time.Time) (string, *models.AuthToken, error) {
+	token, tokenHash, err := services.GenerateToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	stored, err := h.db.CreateToken(userID, tokenHash, kind, name, expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, stored, nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < services.MinPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters", services.MinPasswordLength)
+	}
+	return nil
+}
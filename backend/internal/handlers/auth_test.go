@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+// newTestHandler returns handlers over a migrated database in a temporary
+// directory
+func newTestHandler(t *testing.T) (*Handler, *database.DB) {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "rssy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	return New(db, nil, nil, nil, nil, time.Hour), db
+}
+
+// issueTestToken creates a user and a token for them expiring at expiresAt
+func issueTestToken(t *testing.T, db *database.DB, username string, isAdmin bool, expiresAt *time.Time) (*models.User, string) {
+	t.Helper()
+
+	user, err := db.CreateUser(username, "not-a-real-hash", isAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, hash, err := services.GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateToken(user.ID, hash, models.TokenKindSession, "", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+func TestAuthenticate(t *testing.T) {
+	h, db := newTestHandler(t)
+	expiry := time.Now().Add(time.Hour)
+	user, token := issueTestToken(t, db, "reader", false, &expiry)
+	_, expired := issueTestToken(t, db, "lapsed", false, ptr(time.Now().Add(-time.Minute)))
+
+	var seen *models.User
+	handler := h.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = currentUser(r)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantUser      int64
+	}{
+		{"no header", "", http.StatusUnauthorized, 0},
+		{"other scheme", "Basic " + token, http.StatusUnauthorized, 0},
+		{"empty token", "Bearer  ", http.StatusUnauthorized, 0},
+		{"unknown token", "Bearer nope", http.StatusUnauthorized, 0},
+		{"expired token", "Bearer " + expired, http.StatusUnauthorized, 0},
+		{"valid token", "Bearer " + token, http.StatusOK, user.ID},
+		{"scheme is case-insensitive", "bearer " + token, http.StatusOK, user.ID},
+	}
+	for _, tt := range tests {
+		seen = nil
+		req := httptest.NewRequest(http.MethodGet, "/api/posts", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", tt.name)
+		}
+		if tt.wantUser == 0 && seen != nil {
+			t.Errorf("%s: handler ran as user %d", tt.name, seen.ID)
+		}
+		if tt.wantUser != 0 && (seen == nil || seen.ID != tt.wantUser) {
+			t.Errorf("%s: handler saw user %v, want %d", tt.name, seen, tt.wantUser)
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	h, db := newTestHandler(t)
+	_, reader := issueTestToken(t, db, "reader", false, nil)
+	_, admin := issueTestToken(t, db, "admin", true, nil)
+
+	handler := h.Authenticate(h.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for token, want := range map[string]int{reader: http.StatusForbidden, admin: http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("status %d, want %d", rec.Code, want)
+		}
+	}
+}
+
+func TestTokenFromQuery(t *testing.T) {
+	var authorization, uri string
+	handler := TokenFromQuery("/api/events")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		uri = r.RequestURI
+	}))
+
+	tests := []struct {
+		target, wantAuth, wantURI string
+	}{
+		{"/api/events?access_token=abc&since=5", "Bearer abc", "/api/events?since=5"},
+		{"/api/posts?access_token=abc", "", "/api/posts"},
+		{"/api/posts?limit=5", "", "/api/posts?limit=5"},
+	}
+	for _, tt := range tests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if authorization != tt.wantAuth || uri != tt.wantURI {
+			t.Errorf("%s: Authorization %q, URI %q; want %q, %q", tt.target, authorization, uri, tt.wantAuth, tt.wantURI)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+const (
+	// eventKeepalive is how often an idle stream sends a comment so proxies
+	// do not time it out
+	eventKeepalive = 30 * time.Second
+	// eventRetry is the reconnection delay suggested to clients, in
+	// milliseconds
+	eventRetry = 5000
+)
+
+// StreamEvents handles GET /api/events
+// It is a Server-Sent Events stream of new posts in the user's feeds, feed
+// error changes and the user's unread counts. Clients resume after a
+// disconnect by sending the last event ID they saw in a Last-Event-ID
+// header (or a last_event_id query parameter); a "resync" event means
+// events were missed and state should be reloaded.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	var lastEventID int64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		lastEventID, _ = strconv.ParseInt(value, 10, 64)
+	} else if value := r.URL.Query().Get("last_event_id"); value != "" {
+		lastEventID, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.respondError(w, http.StatusInternalServerError, "Failed to open event stream")
+		return
+	}
+
+	sub := h.events.Subscribe(lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+
+	if sub.Resync {
+		h.writeEvent(w, services.Event{Type: services.EventResync, Data: struct{}{}})
+	}
+	for _, event := range sub.Replay {
+		if event, ok := h.eventForUser(r, event); ok {
+			h.writeEvent(w, event)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Hub shut down or we fell behind; the client reconnects
+				return
+			}
+			if event, ok := h.eventForUser(r, event); ok {
+				h.writeEvent(w, event)
+			}
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// eventVisible reports whether an event is meant for the user
+func (h *Handler) eventVisible(user *models.User, event services.Event) bool {
+	if event.UserID != 0 && event.UserID != user.ID {
+		return false
+	}
+	if event.FeedID != 0 {
+		_, err := h.db.GetUserFeed(user.ID, event.FeedID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			log.Printf("Error checking event subscription: %v", err)
+		}
+		return err == nil
+	}
+	return true
+}
+
+// eventForUser returns the event as the requesting user should see it,
+// leaving out new posts their filter rules dropped and pointing images at
+// the media proxy. It reports false when nothing is left to send.
+func (h *Handler) eventForUser(r *http.Request, event services.Event) (services.Event, bool) {
+	user := currentUser(r)
+	if !h.eventVisible(user, event) {
+		return event, false
+	}
+
+	created, ok := event.Data.(models.NewPostsEvent)
+	if !ok {
+		return event, true
+	}
+
+	ids := make([]int64, len(created.Posts))
+	for i, post := range created.Posts {
+		ids[i] = post.ID
+	}
+	hidden, err := h.db.GetHiddenPostIDs(user.ID, ids)
+	if err != nil {
+		log.Printf("Error checking hidden posts for event: %v", err)
+	}
+
+	// The event is shared by every stream, so posts are copied, not edited
+	posts := make([]models.Post, 0, len(created.Posts))
+	for _, post := range created.Posts {
+		if hidden[post.ID] {
+			continue
+		}
+		h.proxyImages(r, &post)
+		posts = append(posts, post)
+	}
+	if len(posts) == 0 {
+		return event, false
+	}
+	created.Posts = posts
+	event.Data = created
+	return event, true
+}
+
+func (h *Handler) writeEvent(w http.ResponseWriter, event services.Event) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event.Type, err)
+		return
+	}
+
+	if event.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+// publishCounts announces a user's post counts after their read state
+// changed
+func (h *Handler) publishCounts(userID int64) {
+	counts, err := h.db.GetFeedCounts(userID)
+	if err != nil {
+		log.Printf("Error loading feed counts for event: %v", err)
+		return
+	}
+
+	h.events.Publish(services.EventCountsUpdated, userID, 0, counts)
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -11,8 +13,22 @@ import (
 )
 
 // GetAllFeeds handles GET /api/feeds
+// It lists the current user's subscriptions. With ?counts=true each feed
+// also carries its unread and total post counts.
 func (h *Handler) GetAllFeeds(w http.ResponseWriter, r *http.Request) {
-	feeds, err := h.db.GetAllFeeds()
+	userID := currentUser(r).ID
+	if withCounts, _ := strconv.ParseBool(r.URL.Query().Get("counts")); withCounts {
+		feeds, err := h.db.GetAllFeedsWithCounts(userID)
+		if err != nil {
+			h.respondError(w, http.StatusInternalServerError, "Failed to retrieve feeds")
+			return
+		}
+
+		h.respondJSON(w, http.StatusOK, feeds)
+		return
+	}
+
+	feeds, err := h.db.GetUserFeeds(userID)
 	if err != nil {
 		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve feeds")
 		return
@@ -21,6 +37,17 @@ func (h *Handler) GetAllFeeds(w http.ResponseWriter, r *http.Request) {
 	h.respondJSON(w, http.StatusOK, feeds)
 }
 
+// GetCategories handles GET /api/categories
+func (h *Handler) GetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.db.GetCategories(currentUser(r).ID)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve categories")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, categories)
+}
+
 // GetFeedByID handles GET /api/feeds/:id
 func (h *Handler) GetFeedByID(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
@@ -30,9 +57,9 @@ func (h *Handler) GetFeedByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	feed, err := h.db.GetFeedByID(id)
+	feed, err := h.db.GetUserFeed(currentUser(r).ID, id)
 	if err != nil {
-		h.respondError(w, http.StatusNotFound, "Feed not found")
+		h.respondDBError(w, err, "Feed", "Failed to retrieve feed")
 		return
 	}
 
@@ -40,6 +67,10 @@ func (h *Handler) GetFeedByID(w http.ResponseWriter, r *http.Request) {
 }
 
 // CreateFeed handles POST /api/feeds
+// It subscribes the current user to a feed, sharing it if another user
+// already follows the URL. New URLs are fetched and must be, or link to, a
+// working feed; missing metadata is filled in from it. skip_validation
+// stores the feed as given.
 func (h *Handler) CreateFeed(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateFeedRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -47,15 +78,34 @@ func (h *Handler) CreateFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate required fields
-	if req.Name == "" || req.URL == "" {
-		h.respondError(w, http.StatusBadRequest, "Name and URL are required")
+	// Validate required fields; without validation there is no feed to
+	// take a name from
+	if req.URL == "" {
+		h.respondError(w, http.StatusBadRequest, "URL is required")
+		return
+	}
+	if req.SkipValidation && req.Name == "" {
+		h.respondError(w, http.StatusBadRequest, "Name is required when skipping validation")
 		return
 	}
 
-	feed, err := h.db.CreateFeed(req)
-	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "Failed to create feed")
+	feed, err := h.fetcher.CreateFeed(currentUser(r).ID, req)
+	switch {
+	case errors.Is(err, services.ErrInvalidURL):
+		h.respondError(w, http.StatusBadRequest, "URL must be an absolute http(s) URL")
+		return
+	case errors.Is(err, services.ErrNoFeedsFound):
+		h.respondError(w, http.StatusUnprocessableEntity,
+			"URL is not an RSS, Atom or JSON feed and does not link to one")
+		return
+	case errors.Is(err, services.ErrFetchFailed):
+		// The cause stays in the log: it would describe hosts the client
+		// cannot otherwise see
+		log.Printf("Error validating feed %s: %v", req.URL, err)
+		h.respondErrorCode(w, http.StatusUnprocessableEntity, CodeFetchFailed, "Could not fetch the feed URL")
+		return
+	case err != nil:
+		h.respondDBError(w, err, "Feed", "Failed to create feed")
 		return
 	}
 
@@ -63,6 +113,7 @@ func (h *Handler) CreateFeed(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateFeed handles PUT /api/feeds/:id
+// Feeds other users also subscribe to can only be edited by administrators.
 func (h *Handler) UpdateFeed(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -77,9 +128,38 @@ func (h *Handler) UpdateFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 0 reverts to the global interval; anything else must be at least a minute
+	if req.RefreshInterval != nil && *req.RefreshInterval != 0 && *req.RefreshInterval < 60 {
+		h.respondError(w, http.StatusBadRequest, "Refresh interval must be at least 60 seconds")
+		return
+	}
+	// 0 reverts retention limits to the global defaults
+	if (req.RetentionMaxAge != nil && *req.RetentionMaxAge < 0) ||
+		(req.RetentionMaxPosts != nil && *req.RetentionMaxPosts < 0) {
+		h.respondError(w, http.StatusBadRequest, "Retention limits must not be negative")
+		return
+	}
+
+	user := currentUser(r)
+	if _, err := h.db.GetUserFeed(user.ID, id); err != nil {
+		h.respondDBError(w, err, "Feed", "Failed to update feed")
+		return
+	}
+	if !user.IsAdmin {
+		subscribers, err := h.db.CountSubscribers(id)
+		if err != nil {
+			h.respondError(w, http.StatusInternalServerError, "Failed to update feed")
+			return
+		}
+		if subscribers > 1 {
+			h.respondError(w, http.StatusForbidden, "Only administrators can edit feeds shared with other users")
+			return
+		}
+	}
+
 	feed, err := h.db.UpdateFeed(id, req)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "Failed to update feed")
+		h.respondDBError(w, err, "Feed", "Failed to update feed")
 		return
 	}
 
@@ -87,6 +167,8 @@ func (h *Handler) UpdateFeed(w http.ResponseWriter, r *http.Request) {
 }
 
 // DeleteFeed handles DELETE /api/feeds/:id
+// It unsubscribes the current user. The feed itself is only deleted once
+// nobody subscribes to it.
 func (h *Handler) DeleteFeed(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -95,14 +177,47 @@ func (h *Handler) DeleteFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.db.DeleteFeed(id); err != nil {
-		h.respondError(w, http.StatusInternalServerError, "Failed to delete feed")
+	if err := h.db.Unsubscribe(currentUser(r).ID, id); err != nil {
+		h.respondDBError(w, err, "Feed", "Failed to delete feed")
 		return
 	}
 
 	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Feed deleted successfully"})
 }
 
+// DiscoverFeeds handles POST /api/feeds/discover
+// It returns the feeds found at or linked from any URL.
+func (h *Handler) DiscoverFeeds(w http.ResponseWriter, r *http.Request) {
+	var req models.DiscoverFeedsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.URL == "" {
+		h.respondError(w, http.StatusBadRequest, "URL is required")
+		return
+	}
+
+	candidates, err := h.fetcher.DiscoverFeeds(req.URL)
+	switch {
+	case errors.Is(err, services.ErrInvalidURL):
+		h.respondError(w, http.StatusBadRequest, "URL must be an absolute http(s) URL")
+		return
+	case errors.Is(err, services.ErrNoFeedsFound):
+		candidates = []services.FeedCandidate{}
+	case errors.Is(err, services.ErrFetchFailed):
+		log.Printf("Error discovering feeds at %s: %v", req.URL, err)
+		h.respondErrorCode(w, http.StatusBadGateway, CodeFetchFailed, "Could not fetch the URL")
+		return
+	case err != nil:
+		h.respondError(w, http.StatusInternalServerError, "Failed to discover feeds")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, candidates)
+}
+
 // CreateRedditFeed handles POST /api/feeds/reddit
 func (h *Handler) CreateRedditFeed(w http.ResponseWriter, r *http.Request) {
 	var req struct {
@@ -121,32 +236,32 @@ func (h *Handler) CreateRedditFeed(w http.ResponseWriter, r *http.Request) {
 
 	// Create feed from subreddit
 	feedReq := models.CreateFeedRequest{
-		Name:        "r/" + req.Subreddit,
-		URL:         "https://www.reddit.com/r/" + req.Subreddit + "/.rss",
-		Category:    "Reddit",
-		SiteURL:     "https://www.reddit.com/r/" + req.Subreddit,
-		Description: "Reddit /r/" + req.Subreddit + " feed",
+		Name:           "r/" + req.Subreddit,
+		URL:            "https://www.reddit.com/r/" + req.Subreddit + "/.rss",
+		Category:       "Reddit",
+		SiteURL:        "https://www.reddit.com/r/" + req.Subreddit,
+		Description:    "Reddit /r/" + req.Subreddit + " feed",
+		SkipValidation: true,
 	}
 
-	feed, err := h.db.CreateFeed(feedReq)
+	feed, err := h.fetcher.CreateFeed(currentUser(r).ID, feedReq)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "Failed to create Reddit feed")
+		h.respondDBError(w, err, "Feed", "Failed to create Reddit feed")
 		return
 	}
 
 	h.respondJSON(w, http.StatusCreated, feed)
 }
 
-// RefreshAllFeeds manually triggers feed refresh
+// RefreshAllFeeds manually triggers a refresh of the current user's feeds
 func (h *Handler) RefreshAllFeeds(w http.ResponseWriter, r *http.Request) {
-	fetcher := services.NewFeedFetcher(h.db)
-
-	if err := fetcher.FetchAllFeeds(); err != nil {
+	results, err := h.fetcher.FetchUserFeeds(currentUser(r).ID)
+	if err != nil {
 		h.respondError(w, http.StatusInternalServerError, "Failed to refresh feeds")
 		return
 	}
 
-	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Feeds refreshed successfully"})
+	h.respondJSON(w, http.StatusOK, results)
 }
 
 // RefreshFeed manually triggers refresh for specific feed
@@ -158,17 +273,17 @@ func (h *Handler) RefreshFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	feed, err := h.db.GetFeedByID(id)
+	feed, err := h.db.GetUserFeed(currentUser(r).ID, id)
 	if err != nil {
-		h.respondError(w, http.StatusNotFound, "Feed not found")
+		h.respondDBError(w, err, "Feed", "Failed to retrieve feed")
 		return
 	}
 
-	fetcher := services.NewFeedFetcher(h.db)
-	if err := fetcher.FetchFeed(feed); err != nil {
+	result, err := h.fetcher.FetchFeed(feed)
+	if err != nil {
 		h.respondError(w, http.StatusInternalServerError, "Failed to refresh feed")
 		return
 	}
 
-	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Feed refreshed successfully"})
+	h.respondJSON(w, http.StatusOK, result)
 }
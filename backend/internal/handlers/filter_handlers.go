@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+// GetFilterRules handles GET /api/filters
+func (h *Handler) GetFilterRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.db.GetFilterRules(currentUser(r).ID)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve filter rules")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, rules)
+}
+
+// GetFilterRule handles GET /api/filters/:id
+func (h *Handler) GetFilterRule(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid filter rule ID")
+		return
+	}
+
+	rule, err := h.db.GetFilterRule(currentUser(r).ID, id)
+	if err != nil {
+		h.respondDBError(w, err, "Filter rule", "Failed to retrieve filter rule")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, rule)
+}
+
+// CreateFilterRule handles POST /api/filters
+// The rule only acts on posts fetched after it is created.
+func (h *Handler) CreateFilterRule(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateFilterRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rule := newFilterRule(currentUser(r).ID, req)
+	if !h.validateFilterRule(w, rule) {
+		return
+	}
+
+	if err := h.db.CreateFilterRule(&rule); err != nil {
+		h.respondDBError(w, err, "Filter rule", "Failed to create filter rule")
+		return
+	}
+
+	h.respondJSON(w, http.StatusCreated, rule)
+}
+
+// UpdateFilterRule handles PUT /api/filters/:id
+func (h *Handler) UpdateFilterRule(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid filter rule ID")
+		return
+	}
+
+	var req models.UpdateFilterRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rule, err := h.db.GetFilterRule(currentUser(r).ID, id)
+	if err != nil {
+		h.respondDBError(w, err, "Filter rule", "Failed to update filter rule")
+		return
+	}
+
+	applyFilterRuleUpdate(rule, req)
+	if !h.validateFilterRule(w, *rule) {
+		return
+	}
+
+	if err := h.db.UpdateFilterRule(rule); err != nil {
+		h.respondDBError(w, err, "Filter rule", "Failed to update filter rule")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, rule)
+}
+
+// DeleteFilterRule handles DELETE /api/filters/:id
+func (h *Handler) DeleteFilterRule(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid filter rule ID")
+		return
+	}
+
+	if err := h.db.DeleteFilterRule(currentUser(r).ID, id); err != nil {
+		h.respondDBError(w, err, "Filter rule", "Failed to delete filter rule")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Filter rule deleted successfully"})
+}
+
+// DryRunFilterRule handles GET /api/filters/:id/dry-run
+// It lists the existing posts the saved rule would match (up to limit).
+func (h *Handler) DryRunFilterRule(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid filter rule ID")
+		return
+	}
+
+	rule, err := h.db.GetFilterRule(currentUser(r).ID, id)
+	if err != nil {
+		h.respondDBError(w, err, "Filter rule", "Failed to run filter rule")
+		return
+	}
+
+	h.dryRunFilterRule(w, r, *rule)
+}
+
+// DryRunNewFilterRule handles POST /api/filters/dry-run
+// It takes the same body as CreateFilterRule and lists the existing posts
+// that rule would match (up to limit) without saving it.
+func (h *Handler) DryRunNewFilterRule(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateFilterRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rule := newFilterRule(currentUser(r).ID, req)
+	if !h.validateFilterRule(w, rule) {
+		return
+	}
+
+	h.dryRunFilterRule(w, r, rule)
+}
+
+func (h *Handler) dryRunFilterRule(w http.ResponseWriter, r *http.Request, rule models.FilterRule) {
+	m, err := services.CompileFilterRule(rule)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, _ := parsePagination(r)
+	result, err := services.DryRunFilterRule(h.db, m, limit)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to run filter rule")
+		return
+	}
+	for i := range result.Posts {
+		h.proxyImages(r, &result.Posts[i].Post)
+	}
+
+	h.respondJSON(w, http.StatusOK, result)
+}
+
+// validateFilterRule checks a rule's pattern, field and action and that its
+// feed scope is one of the user's feeds, responding with the error when it
+// is not valid
+func (h *Handler) validateFilterRule(w http.ResponseWriter, rule models.FilterRule) bool {
+	if _, err := services.CompileFilterRule(rule); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if rule.FeedID != nil {
+		if _, err := h.db.GetUserFeed(rule.UserID, *rule.FeedID); err != nil {
+			h.respondDBError(w, err, "Feed", "Failed to save filter rule")
+			return false
+		}
+	}
+
+	return true
+}
+
+// newFilterRule builds a rule from a create request, applying its defaults
+func newFilterRule(userID int64, req models.CreateFilterRuleRequest) models.FilterRule {
+	rule := models.FilterRule{
+		UserID:    userID,
+		Name:      req.Name,
+		Field:     req.Field,
+		MatchType: req.MatchType,
+		Pattern:   req.Pattern,
+		Action:    req.Action,
+		Tag:       req.Tag,
+		IsActive:  true,
+	}
+	if rule.MatchType == "" {
+		rule.MatchType = models.FilterMatchSubstring
+	}
+	if req.FeedID != nil && *req.FeedID > 0 {
+		rule.FeedID = req.FeedID
+	}
+	if req.Category != nil && *req.Category != "" {
+		rule.Category = req.Category
+	}
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+	return rule
+}
+
+// applyFilterRuleUpdate copies the fields set in an update request onto
+// the rule
+func applyFilterRuleUpdate(rule *models.FilterRule, req models.UpdateFilterRuleRequest) {
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Field != nil {
+		rule.Field = *req.Field
+	}
+	if req.MatchType != nil {
+		rule.MatchType = *req.MatchType
+	}
+	if req.Pattern != nil {
+		rule.Pattern = *req.Pattern
+	}
+	if req.FeedID != nil {
+		rule.FeedID = nil
+		if *req.FeedID > 0 {
+			rule.FeedID = req.FeedID
+		}
+	}
+	if req.Category != nil {
+		rule.Category = nil
+		if *req.Category != "" {
+			rule.Category = req.Category
+		}
+	}
+	if req.Action != nil {
+		rule.Action = *req.Action
+	}
+	if req.Tag != nil {
+		rule.Tag = *req.Tag
+	}
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+}
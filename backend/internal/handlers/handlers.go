@@ -2,24 +2,43 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/services"
 )
 
 type Handler struct {
-	db *database.DB
+	db         *database.DB
+	fetcher    *services.FeedFetcher
+	events     *services.EventHub
+	media      *services.MediaProxy
+	webhooks   *services.WebhookDispatcher
+	sessionTTL time.Duration
 }
 
-func New(db *database.DB) *Handler {
-	return &Handler{db: db}
+// New creates the API handlers. Sessions issued by logging in last for
+// sessionTTL. Post images are served through media, which is nil when the
+// media proxy is disabled. Webhook targets are checked against webhooks.
+func New(db *database.DB, fetcher *services.FeedFetcher, events *services.EventHub, media *services.MediaProxy, webhooks *services.WebhookDispatcher, sessionTTL time.Duration) *Handler {
+	return &Handler{db: db, fetcher: fetcher, events: events, media: media, webhooks: webhooks, sessionTTL: sessionTTL}
 }
 
 // Response helpers
 type Response struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success    bool        `json:"success"`
+	Data       interface{} `json:"data,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Code       string      `json:"code,omitempty"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination describes how to fetch the page after the current one
+type Pagination struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
 }
 
 func (h *Handler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -31,11 +50,73 @@ func (h *Handler) respondJSON(w http.ResponseWriter, status int, data interface{
 	})
 }
 
+func (h *Handler) respondPage(w http.ResponseWriter, status int, data interface{}, pagination *Pagination) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{
+		Success:    status < 400,
+		Data:       data,
+		Pagination: pagination,
+	})
+}
+
+// Machine-readable error codes carried in Response.Code
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeConstraintViolation = "constraint_violation"
+	CodeUnprocessable       = "unprocessable"
+	CodeUpstreamError       = "upstream_error"
+	CodeFetchFailed         = "fetch_failed"
+	CodeUnavailable         = "unavailable"
+	CodeInternal            = "internal_error"
+)
+
+// statusCodes gives the default error code for each status respondError is
+// used with
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeInvalidRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusUnprocessableEntity: CodeUnprocessable,
+	http.StatusBadGateway:          CodeUpstreamError,
+	http.StatusServiceUnavailable:  CodeUnavailable,
+	http.StatusInternalServerError: CodeInternal,
+}
+
 func (h *Handler) respondError(w http.ResponseWriter, status int, message string) {
+	h.respondErrorCode(w, status, statusCodes[status], message)
+}
+
+func (h *Handler) respondErrorCode(w http.ResponseWriter, status int, code, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(Response{
 		Success: false,
 		Error:   message,
+		Code:    code,
 	})
 }
+
+// respondDBError maps a repository error to a response: ErrNotFound is a
+// 404, ErrConflict a 409 and ErrConstraint a 422, each described in terms
+// of the given resource ("Feed", "Post"). Any other error is a 500 with
+// the fallback message.
+func (h *Handler) respondDBError(w http.ResponseWriter, err error, resource, fallback string) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		h.respondErrorCode(w, http.StatusNotFound, CodeNotFound, resource+" not found")
+	case errors.Is(err, database.ErrConflict):
+		h.respondErrorCode(w, http.StatusConflict, CodeConflict, resource+" already exists")
+	case errors.Is(err, database.ErrConstraint):
+		h.respondErrorCode(w, http.StatusUnprocessableEntity, CodeConstraintViolation,
+			resource+" violates a data constraint")
+	default:
+		h.respondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+// ProxyMedia handles GET /api/media/proxy?url=&sig=
+// It serves an image from the media cache, fetching it on first use. The
+// URL must carry the signature the API gave it, so this needs no session
+// and works in plain <img> tags. An optional w parameter asks for a
+// thumbnail of one of the sizes in services.ThumbnailWidths.
+func (h *Handler) ProxyMedia(w http.ResponseWriter, r *http.Request) {
+	if h.media == nil {
+		h.respondError(w, http.StatusNotFound, "Media proxy is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	raw := query.Get("url")
+	if raw == "" || !h.media.Verify(raw, query.Get("sig")) {
+		h.respondError(w, http.StatusForbidden, "Invalid media signature")
+		return
+	}
+
+	var width int
+	if value := query.Get("w"); value != "" {
+		var err error
+		width, err = strconv.Atoi(value)
+		if err != nil || !slices.Contains(services.ThumbnailWidths, width) {
+			h.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid thumbnail width: %s", value))
+			return
+		}
+	}
+
+	img, err := h.media.Open(r.Context(), raw, width)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMediaTooLarge), errors.Is(err, services.ErrUnsupportedMedia):
+			h.respondError(w, http.StatusBadGateway, "Failed to proxy image: "+err.Error())
+		case r.Context().Err() != nil:
+			// The client went away
+		default:
+			log.Printf("Error proxying %s: %v", raw, err)
+			h.respondError(w, http.StatusBadGateway, "Failed to fetch image")
+		}
+		return
+	}
+	defer img.Close()
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=604800, immutable")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", img.ModTime, img)
+}
+
+// proxyImages points the images of a post at the media proxy, when it is
+// enabled. Proxied URLs use the host the request was made to unless a
+// public URL is configured.
+func (h *Handler) proxyImages(r *http.Request, post *models.Post) {
+	if h.media == nil {
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	h.media.RewritePost(post, scheme+"://"+r.Host)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+// maxOPMLSize limits the size of an uploaded OPML document
+const maxOPMLSize = 10 << 20
+
+// ImportOPML handles POST /api/feeds/import/opml
+// The document can be sent as the raw request body or as a multipart
+// upload in a field named "file".
+func (h *Handler) ImportOPML(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxOPMLSize)
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			h.respondError(w, http.StatusBadRequest, "Missing OPML file upload")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	report, err := services.ImportOPML(h.db, currentUser(r).ID, body)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, report)
+}
+
+// ExportOPML handles GET /api/feeds/export/opml
+// It exports the current user's subscriptions.
+func (h *Handler) ExportOPML(w http.ResponseWriter, r *http.Request) {
+	feeds, err := h.db.GetUserFeeds(currentUser(r).ID)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve feeds")
+		return
+	}
+
+	doc, err := services.ExportOPML(feeds)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to export feeds")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="rssy.opml"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(doc)
+}
@@ -2,42 +2,75 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
 )
 
 // GetAllPosts handles GET /api/posts
+// Supported filters: is_read, is_starred, category, feed_id
+// (comma-separated or repeated), author, tag, published_after,
+// published_before and sort (newest, oldest, fetched or starred). Pages are selected with
+// limit plus either the opaque cursor from the previous response or a
+// legacy offset.
 func (h *Handler) GetAllPosts(w http.ResponseWriter, r *http.Request) {
-	// Parse pagination parameters
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
-
-	limit := 50 // default
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
+	filter, err := parsePostFilter(r)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.listPosts(w, r, filter)
+}
+
+// SearchPosts handles GET /api/posts/search?q=
+func (h *Handler) SearchPosts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		h.respondError(w, http.StatusBadRequest, "Search query is required")
+		return
 	}
 
-	offset := 0 // default
-	if offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
+	var feedID int64
+	if feedIDStr := query.Get("feed_id"); feedIDStr != "" {
+		id, err := strconv.ParseInt(feedIDStr, 10, 64)
+		if err != nil {
+			h.respondError(w, http.StatusBadRequest, "Invalid feed ID")
+			return
 		}
+		feedID = id
 	}
 
-	posts, err := h.db.GetAllPosts(limit, offset)
+	limit, offset := parsePagination(r)
+
+	results, err := h.db.SearchPosts(currentUser(r).ID, q, feedID, query.Get("category"), limit, offset)
+	if errors.Is(err, database.ErrSearchUnavailable) {
+		h.respondError(w, http.StatusServiceUnavailable, "Full-text search is not available")
+		return
+	}
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		h.respondError(w, http.StatusInternalServerError, "Failed to search posts")
 		return
 	}
+	for i := range results.Results {
+		h.proxyImages(r, &results.Results[i].Post)
+	}
 
-	h.respondJSON(w, http.StatusOK, posts)
+	h.respondJSON(w, http.StatusOK, results)
 }
 
 // GetPostsByFeed handles GET /api/posts/feed/:feedId
+// It accepts the same filters as GetAllPosts, scoped to a single feed.
 func (h *Handler) GetPostsByFeed(w http.ResponseWriter, r *http.Request) {
 	feedIDStr := chi.URLParam(r, "feedId")
 	feedID, err := strconv.ParseInt(feedIDStr, 10, 64)
@@ -46,34 +79,56 @@ func (h *Handler) GetPostsByFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse pagination
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
-
-	limit := 50
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
+	filter, err := parsePostFilter(r)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	filter.FeedIDs = []int64{feedID}
 
-	offset := 0
-	if offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
-		}
+	h.listPosts(w, r, filter)
+}
+
+// GetStarredPosts handles GET /api/posts/starred
+// It accepts the same filters as GetAllPosts and lists the most recently
+// starred posts first unless another sort is given.
+func (h *Handler) GetStarredPosts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parsePostFilter(r)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	starred := true
+	filter.IsStarred = &starred
+	if filter.Sort == "" {
+		filter.Sort = models.SortStarred
 	}
 
-	posts, err := h.db.GetPostsByFeedID(feedID, limit, offset)
+	h.listPosts(w, r, filter)
+}
+
+func (h *Handler) listPosts(w http.ResponseWriter, r *http.Request, filter models.PostFilter) {
+	page, err := h.db.ListPosts(filter)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		h.respondError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
 	if err != nil {
 		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve posts")
 		return
 	}
+	for i := range page.Posts {
+		h.proxyImages(r, &page.Posts[i].Post)
+	}
 
-	h.respondJSON(w, http.StatusOK, posts)
+	h.respondPage(w, http.StatusOK, page.Posts, &Pagination{
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+	})
 }
 
 // MarkPostRead handles PATCH /api/posts/:id/read
+// With "cluster": true, every copy of the post's story is marked as well.
 func (h *Handler) MarkPostRead(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -83,7 +138,42 @@ func (h *Handler) MarkPostRead(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		IsRead bool `json:"is_read"`
+		IsRead  bool `json:"is_read"`
+		Cluster bool `json:"cluster"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userID := currentUser(r).ID
+	if err := h.db.MarkPostAsRead(userID, id, req.IsRead); err != nil {
+		h.respondDBError(w, err, "Post", "Failed to update post")
+		return
+	}
+	if req.Cluster {
+		if _, err := h.db.MarkStoriesRead(userID, []int64{id}, req.IsRead); err != nil {
+			h.respondError(w, http.StatusInternalServerError, "Failed to update post")
+			return
+		}
+	}
+	h.publishCounts(userID)
+
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Post updated successfully"})
+}
+
+// StarPost handles PATCH /api/posts/:id/star
+func (h *Handler) StarPost(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	var req struct {
+		IsStarred bool `json:"is_starred"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -91,20 +181,178 @@ func (h *Handler) MarkPostRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.db.MarkPostAsRead(id, req.IsRead); err != nil {
-		h.respondError(w, http.StatusInternalServerError, "Failed to update post")
+	if err := h.db.StarPost(currentUser(r).ID, id, req.IsStarred); err != nil {
+		h.respondDBError(w, err, "Post", "Failed to update post")
 		return
 	}
 
 	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Post updated successfully"})
 }
 
-// DeleteAllPosts handles DELETE /api/posts
+// MarkPostsRead handles POST /api/posts/read
+// It marks every post in a feed, a category, older than a timestamp, or all
+// posts at once, depending on which scope fields the body sets.
+func (h *Handler) MarkPostsRead(w http.ResponseWriter, r *http.Request) {
+	var req models.MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	filter := models.PostFilter{
+		UserID:    currentUser(r).ID,
+		Category:  req.Category,
+		OlderThan: req.OlderThan,
+	}
+	if req.FeedID != nil {
+		filter.FeedIDs = []int64{*req.FeedID}
+	}
+
+	updated, err := h.db.MarkPostsReadByFilter(filter, req.IsRead == nil || *req.IsRead)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to update posts")
+		return
+	}
+	if updated > 0 {
+		h.publishCounts(filter.UserID)
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]int64{"updated": updated})
+}
+
+// BatchMarkPostsRead handles POST /api/posts/read/batch
+func (h *Handler) BatchMarkPostsRead(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchMarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		h.respondError(w, http.StatusBadRequest, "At least one post ID is required")
+		return
+	}
+
+	userID := currentUser(r).ID
+	markRead := h.db.MarkPostsRead
+	if req.Cluster {
+		markRead = h.db.MarkStoriesRead
+	}
+	updated, err := markRead(userID, req.IDs, req.IsRead == nil || *req.IsRead)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to update posts")
+		return
+	}
+	if updated > 0 {
+		h.publishCounts(userID)
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]int64{"updated": updated})
+}
+
+// DeleteAllPosts handles DELETE /api/posts (administrators only)
+// It deletes posts for every user; posts anyone has starred are kept.
 func (h *Handler) DeleteAllPosts(w http.ResponseWriter, r *http.Request) {
 	if err := h.db.DeleteAllPosts(); err != nil {
 		h.respondError(w, http.StatusInternalServerError, "Failed to delete posts")
 		return
 	}
 
-	h.respondJSON(w, http.StatusOK, map[string]string{"message": "All posts deleted successfully"})
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "All unstarred posts deleted successfully"})
+}
+
+// parsePagination reads the limit and offset query parameters, falling back
+// to the defaults for missing or invalid values
+func parsePagination(r *http.Request) (limit, offset int) {
+	limit = 50 // default
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	offset = 0 // default
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+
+	return limit, offset
+}
+
+// parsePostFilter reads post listing filters from the query string
+func parsePostFilter(r *http.Request) (models.PostFilter, error) {
+	query := r.URL.Query()
+	filter := models.PostFilter{
+		UserID:   currentUser(r).ID,
+		Category: query.Get("category"),
+		Author:   query.Get("author"),
+		Tag:      query.Get("tag"),
+		Cursor:   query.Get("cursor"),
+	}
+	filter.Limit, filter.Offset = parsePagination(r)
+
+	for _, value := range query["feed_id"] {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid feed ID: %s", part)
+			}
+			filter.FeedIDs = append(filter.FeedIDs, id)
+		}
+	}
+
+	if value := query.Get("is_read"); value != "" {
+		isRead, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid is_read value: %s", value)
+		}
+		filter.IsRead = &isRead
+	}
+
+	if value := query.Get("is_starred"); value != "" {
+		isStarred, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid is_starred value: %s", value)
+		}
+		filter.IsStarred = &isStarred
+	}
+
+	for param, dest := range map[string]**time.Time{
+		"published_after":  &filter.PublishedAfter,
+		"published_before": &filter.PublishedBefore,
+	} {
+		if value := query.Get(param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s value: %s", param, value)
+			}
+			*dest = &t
+		}
+	}
+
+	if value := query.Get("collapse"); value != "" {
+		collapse, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid collapse value: %s", value)
+		}
+		filter.Collapse = collapse
+	}
+
+	switch sort := query.Get("sort"); sort {
+	case "", models.SortNewest, models.SortOldest, models.SortFetched, models.SortStarred:
+		filter.Sort = sort
+	default:
+		return filter, fmt.Errorf("Invalid sort order: %s", sort)
+	}
+
+	return filter, nil
+}
+
+// parseTimeParam accepts either an RFC 3339 timestamp or a plain date
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
 }
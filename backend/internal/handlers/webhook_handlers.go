@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/justanotherspy/rssy/internal/services"
+)
+
+// checkWebhookURL responds with a 400 and returns false when webhooks may
+// not be sent to raw
+func (h *Handler) checkWebhookURL(w http.ResponseWriter, raw string) bool {
+	err := h.webhooks.CheckURL(raw)
+	switch {
+	case errors.Is(err, services.ErrPrivateWebhookURL):
+		h.respondError(w, http.StatusBadRequest, "URL must not point at a local or private address")
+	case err != nil:
+		h.respondError(w, http.StatusBadRequest, "URL must be an absolute http(s) URL")
+	}
+	return err == nil
+}
+
+// GetWebhooks handles GET /api/webhooks
+func (h *Handler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.db.GetWebhooks(currentUser(r).ID)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, "Failed to retrieve webhooks")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, webhooks)
+}
+
+// GetWebhook handles GET /api/webhooks/:id
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	webhook, err := h.db.GetWebhook(currentUser(r).ID, id)
+	if err != nil {
+		h.respondDBError(w, err, "Webhook", "Failed to retrieve webhook")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, webhook)
+}
+
+// CreateWebhook handles POST /api/webhooks
+// A secret is generated when none is given; it is only returned in this
+// response.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !h.checkWebhookURL(w, req.URL) {
+		return
+	}
+
+	userID := currentUser(r).ID
+	if req.FeedID != nil && *req.FeedID > 0 {
+		if _, err := h.db.GetUserFeed(userID, *req.FeedID); err != nil {
+			h.respondDBError(w, err, "Feed", "Failed to create webhook")
+			return
+		}
+	}
+
+	if req.Secret == "" {
+		secret, err := services.GenerateWebhookSecret()
+		if err != nil {
+			h.respondError(w, http.StatusInternalServerError, "Failed to create webhook")
+			return
+		}
+		req.Secret = secret
+	}
+
+	webhook, err := h.db.CreateWebhook(userID, req)
+	if err != nil {
+		h.respondDBError(w, err, "Webhook", "Failed to create webhook")
+		return
+	}
+
+	h.respondJSON(w, http.StatusCreated, webhook)
+}
+
+// UpdateWebhook handles PUT /api/webhooks/:id
+func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	var req models.UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.URL != nil && !h.checkWebhookURL(w, *req.URL) {
+		return
+	}
+	if req.Secret != nil && *req.Secret == "" {
+		h.respondError(w, http.StatusBadRequest, "Secret must not be empty")
+		return
+	}
+
+	userID := currentUser(r).ID
+	if req.FeedID != nil && *req.FeedID > 0 {
+		if _, err := h.db.GetUserFeed(userID, *req.FeedID); err != nil {
+			h.respondDBError(w, err, "Feed", "Failed to update webhook")
+			return
+		}
+	}
+
+	webhook, err := h.db.UpdateWebhook(userID, id, req)
+	if err != nil {
+		h.respondDBError(w, err, "Webhook", "Failed to update webhook")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, webhook)
+}
+
+// DeleteWebhook handles DELETE /api/webhooks/:id
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := h.db.DeleteWebhook(currentUser(r).ID, id); err != nil {
+		h.respondDBError(w, err, "Webhook", "Failed to delete webhook")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+}
+
+// GetWebhookDeliveries handles GET /api/webhooks/:id/deliveries
+// It returns the webhook's delivery log, newest first.
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	limit, offset := parsePagination(r)
+	deliveries, err := h.db.GetDeliveries(currentUser(r).ID, id, limit, offset)
+	if err != nil {
+		h.respondDBError(w, err, "Webhook", "Failed to retrieve deliveries")
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, deliveries)
+}
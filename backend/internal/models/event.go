@@ -0,0 +1,23 @@
+package models
+
+// NewPostsEvent announces posts stored by a fetch
+type NewPostsEvent struct {
+	FeedID   int64  `json:"feed_id"`
+	FeedName string `json:"feed_name"`
+	Posts    []Post `json:"posts"`
+}
+
+// FeedStatusEvent announces a change in a feed's error state
+type FeedStatusEvent struct {
+	FeedID     int64   `json:"feed_id"`
+	IsActive   bool    `json:"is_active"`
+	ErrorCount int     `json:"error_count"`
+	LastError  *string `json:"last_error"`
+}
+
+// FeedCounts carries a feed's post counts for one user
+type FeedCounts struct {
+	FeedID      int64 `json:"feed_id"`
+	UnreadCount int   `json:"unread_count"`
+	TotalCount  int   `json:"total_count"`
+}
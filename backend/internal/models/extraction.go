@@ -0,0 +1,24 @@
+package models
+
+// Content extraction states. Extractions that succeed are removed from the
+// queue, so only pending and failed ones are stored.
+const (
+	ExtractionPending = "pending"
+	ExtractionFailed  = "failed"
+)
+
+// PendingExtraction is a post whose article page is due to be fetched,
+// for its full text, its lead image or both. It is internal to the
+// extractor and never serialised.
+type PendingExtraction struct {
+	PostID   int64
+	Link     string
+	Attempts int
+	// FullText is set when the post's feed extracts full text
+	FullText bool
+	// Image is set when the post still has no lead image
+	Image bool
+	// ResolveURL is set when the post's link has not been followed to
+	// its canonical URL yet
+	ResolveURL bool
+}
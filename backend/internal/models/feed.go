@@ -3,33 +3,69 @@ package models
 import "time"
 
 type Feed struct {
-	ID            int64      `json:"id"`
-	Name          string     `json:"name"`
-	URL           string     `json:"url"`
-	Category      *string    `json:"category"`
-	SiteURL       *string    `json:"site_url"`
-	Description   *string    `json:"description"`
-	IsActive      bool       `json:"is_active"`
-	LastFetchedAt *time.Time `json:"last_fetched_at"`
-	ErrorCount    int        `json:"error_count"`
-	LastError     *string    `json:"last_error"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+	ID                int64      `json:"id"`
+	Name              string     `json:"name"`
+	URL               string     `json:"url"`
+	Category          *string    `json:"category"`
+	SiteURL           *string    `json:"site_url"`
+	Description       *string    `json:"description"`
+	IsActive          bool       `json:"is_active"`
+	LastFetchedAt     *time.Time `json:"last_fetched_at"`
+	ErrorCount        int        `json:"error_count"`
+	LastError         *string    `json:"last_error"`
+	LastErrorAt       *time.Time `json:"last_error_at"`
+	ETag              *string    `json:"etag"`
+	LastModified      *string    `json:"last_modified"`
+	RefreshInterval   *int       `json:"refresh_interval"`
+	NextFetchAt       *time.Time `json:"next_fetch_at"`
+	RetentionMaxAge   *int       `json:"retention_max_age"`
+	RetentionMaxPosts *int       `json:"retention_max_posts"`
+	FetchFullText     bool       `json:"fetch_full_text"`
+	FetchPageImage    bool       `json:"fetch_page_image"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+type FeedWithCounts struct {
+	Feed
+	UnreadCount int `json:"unread_count"`
+	TotalCount  int `json:"total_count"`
+}
+
+// Category aggregates the feeds sharing a category; Name is nil for feeds
+// without one
+type Category struct {
+	Name        *string `json:"name"`
+	FeedCount   int     `json:"feed_count"`
+	UnreadCount int     `json:"unread_count"`
+	TotalCount  int     `json:"total_count"`
 }
 
 type CreateFeedRequest struct {
-	Name        string `json:"name"`
-	URL         string `json:"url"`
-	Category    string `json:"category"`
-	SiteURL     string `json:"site_url"`
-	Description string `json:"description"`
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	Category       string `json:"category"`
+	SiteURL        string `json:"site_url"`
+	Description    string `json:"description"`
+	FetchFullText  bool   `json:"fetch_full_text"`
+	FetchPageImage bool   `json:"fetch_page_image"`
+	SkipValidation bool   `json:"skip_validation"`
+}
+
+type DiscoverFeedsRequest struct {
+	URL string `json:"url"`
 }
 
 type UpdateFeedRequest struct {
-	Name        *string `json:"name"`
-	URL         *string `json:"url"`
-	Category    *string `json:"category"`
-	SiteURL     *string `json:"site_url"`
-	Description *string `json:"description"`
-	IsActive    *bool   `json:"is_active"`
+	Name              *string `json:"name"`
+	URL               *string `json:"url"`
+	Category          *string `json:"category"`
+	SiteURL           *string `json:"site_url"`
+	Description       *string `json:"description"`
+	IsActive          *bool   `json:"is_active"`
+	RefreshInterval   *int    `json:"refresh_interval"`
+	RetentionMaxAge   *int    `json:"retention_max_age"`
+	RetentionMaxPosts *int    `json:"retention_max_posts"`
+	FetchFullText     *bool   `json:"fetch_full_text"`
+	FetchPageImage    *bool   `json:"fetch_page_image"`
 }
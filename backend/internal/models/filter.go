@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+// Fields a filter rule can match on. FilterFieldContent covers the text of
+// both the description and the content of a post, without their markup.
+const (
+	FilterFieldTitle   = "title"
+	FilterFieldContent = "content"
+	FilterFieldAuthor  = "author"
+	FilterFieldLink    = "link"
+)
+
+// Filter rule match types. Substring matches ignore case; regular
+// expressions use Go's RE2 syntax and are case sensitive unless they start
+// with (?i).
+const (
+	FilterMatchSubstring = "substring"
+	FilterMatchRegex     = "regex"
+)
+
+// Filter rule actions, applied to the rule owner's view of new posts
+const (
+	FilterActionMarkRead = "mark_read"
+	FilterActionStar     = "star"
+	FilterActionDrop     = "drop"
+	FilterActionTag      = "tag"
+)
+
+// FilterRule acts on new posts that match it as they are fetched. FeedID
+// and Category optionally narrow it to one feed or category of the owner's
+// subscriptions; otherwise it applies to all of them.
+type FilterRule struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Name      string    `json:"name"`
+	Field     string    `json:"field"`
+	MatchType string    `json:"match_type"`
+	Pattern   string    `json:"pattern"`
+	FeedID    *int64    `json:"feed_id"`
+	Category  *string   `json:"category"`
+	Action    string    `json:"action"`
+	Tag       string    `json:"tag,omitempty"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateFilterRuleRequest defines a new rule. MatchType defaults to
+// substring and IsActive to true; Tag is required for the tag action.
+type CreateFilterRuleRequest struct {
+	Name      string  `json:"name"`
+	Field     string  `json:"field"`
+	MatchType string  `json:"match_type"`
+	Pattern   string  `json:"pattern"`
+	FeedID    *int64  `json:"feed_id"`
+	Category  *string `json:"category"`
+	Action    string  `json:"action"`
+	Tag       string  `json:"tag"`
+	IsActive  *bool   `json:"is_active"`
+}
+
+// UpdateFilterRuleRequest changes the fields that are set. A FeedID of 0
+// or an empty Category removes that scope.
+type UpdateFilterRuleRequest struct {
+	Name      *string `json:"name"`
+	Field     *string `json:"field"`
+	MatchType *string `json:"match_type"`
+	Pattern   *string `json:"pattern"`
+	FeedID    *int64  `json:"feed_id"`
+	Category  *string `json:"category"`
+	Action    *string `json:"action"`
+	Tag       *string `json:"tag"`
+	IsActive  *bool   `json:"is_active"`
+}
+
+// FilterActions is what a user's matching rules do to one new post
+type FilterActions struct {
+	MarkRead bool
+	Star     bool
+	Drop     bool
+	Tags     []string
+}
+
+// FilterDryRun lists the existing posts a rule would match
+type FilterDryRun struct {
+	Posts []PostWithFeed `json:"posts"`
+	// Scanned is how many of the user's posts were checked
+	Scanned int `json:"scanned"`
+	// Truncated is set when the scan stopped before checking every post
+	Truncated bool `json:"truncated"`
+}
@@ -9,16 +9,138 @@ type Post struct {
 	Link        string     `json:"link"`
 	Description string     `json:"description"`
 	Content     string     `json:"content"`
+	FullContent *string    `json:"full_content"`
+	Excerpt     string     `json:"excerpt"`
 	Author      string     `json:"author"`
 	PublishedAt *time.Time `json:"published_at"`
 	ImageURL    string     `json:"image_url"`
+	ImageSource string     `json:"image_source"`
 	GUID        string     `json:"guid"`
 	IsRead      bool       `json:"is_read"`
+	IsStarred   bool       `json:"is_starred"`
+	StarredAt   *time.Time `json:"starred_at"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
+	// ClusterID identifies the story the post belongs to: the ID of the
+	// story's first post, which is the post's own ID if no other feed
+	// carried it
+	ClusterID int64 `json:"cluster_id"`
+	// Tags are the user's tags, set by filter rules
+	Tags []string `json:"tags,omitempty"`
+	// TextContent is the plain text of the post's body, indexed for search
+	TextContent string `json:"-"`
+	// URLKey and TitleHash are what story clustering compares posts by
+	URLKey    string `json:"-"`
+	TitleHash uint64 `json:"-"`
 }
 
 type PostWithFeed struct {
 	Post
 	FeedName string `json:"feed_name"`
+	// AlsoIn lists the other copies of the story in collapsed listings
+	AlsoIn []StoryCopy `json:"also_in,omitempty"`
+}
+
+// StoryCopy is another post of the same story, in another feed
+type StoryCopy struct {
+	PostID   int64  `json:"post_id"`
+	FeedID   int64  `json:"feed_id"`
+	FeedName string `json:"feed_name"`
+	Link     string `json:"link"`
+	IsRead   bool   `json:"is_read"`
+}
+
+// StorySignature is what story clustering knows about a post. It is
+// internal to the clusterer and never serialised.
+type StorySignature struct {
+	PostID    int64
+	FeedID    int64
+	ClusterID int64
+	URLKey    string
+	TitleHash uint64
+}
+
+// Lead image sources: the strategy that found a post's image_url. Posts
+// without an image, or stored before sources were recorded, have none.
+const (
+	ImageSourceMediaContent   = "media_content"
+	ImageSourceMediaThumbnail = "media_thumbnail"
+	ImageSourceEnclosure      = "enclosure"
+	ImageSourceITunes         = "itunes"
+	ImageSourceItem           = "item_image"
+	ImageSourceContent        = "content"
+	ImageSourceOpenGraph      = "og_image"
+	ImageSourceTwitter        = "twitter_image"
+)
+
+// Post sort orders accepted by PostFilter
+const (
+	SortNewest  = "newest"
+	SortOldest  = "oldest"
+	SortFetched = "fetched"
+	SortStarred = "starred"
+)
+
+// PostFilter narrows and orders a post listing. Zero values mean "no
+// filter"; an empty Sort means SortNewest. SortStarred orders by star time
+// and only lists starred posts. UserID is required: listings only cover
+// that user's subscriptions, with their read and star state. OlderThan
+// matches posts published before it, or fetched before it when they have
+// no publication date. Collapse lists each story once, as its first post
+// matching the filter.
+type PostFilter struct {
+	UserID          int64
+	FeedIDs         []int64
+	Category        string
+	IsRead          *bool
+	IsStarred       *bool
+	Author          string
+	Tag             string
+	PublishedAfter  *time.Time
+	PublishedBefore *time.Time
+	OlderThan       *time.Time
+	Sort            string
+	Collapse        bool
+	Limit           int
+	Offset          int
+	Cursor          string
+}
+
+// PostPage is one page of a post listing
+type PostPage struct {
+	Posts      []PostWithFeed
+	NextCursor string
+	HasMore    bool
+}
+
+// MarkReadRequest selects posts to mark read or unread in bulk. Omitting
+// every scope field selects all posts; IsRead defaults to true.
+type MarkReadRequest struct {
+	FeedID    *int64     `json:"feed_id"`
+	Category  string     `json:"category"`
+	OlderThan *time.Time `json:"older_than"`
+	IsRead    *bool      `json:"is_read"`
+}
+
+// BatchMarkReadRequest marks an explicit list of posts read or unread;
+// IsRead defaults to true. Cluster extends it to every copy of their
+// stories.
+type BatchMarkReadRequest struct {
+	IDs     []int64 `json:"ids"`
+	IsRead  *bool   `json:"is_read"`
+	Cluster bool    `json:"cluster"`
+}
+
+type PostSearchResult struct {
+	PostWithFeed
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+	Rank           float64 `json:"rank"`
+}
+
+type PostSearchResults struct {
+	Results []PostSearchResult `json:"results"`
+	Total   int                `json:"total"`
+	Limit   int                `json:"limit"`
+	Offset  int                `json:"offset"`
 }
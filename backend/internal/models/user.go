@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+type User struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	IsAdmin   bool      `json:"is_admin"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Token kinds: sessions are issued by logging in and expire, API tokens are
+// created explicitly and last until revoked
+const (
+	TokenKindSession = "session"
+	TokenKindAPI     = "api"
+)
+
+// AuthToken describes an issued token; the secret itself is only ever
+// returned once, when it is created
+type AuthToken struct {
+	ID         int64      `json:"id"`
+	Kind       string     `json:"kind"`
+	Name       string     `json:"name"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginResponse carries a newly issued token
+type LoginResponse struct {
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	User      *User      `json:"user"`
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type CreateTokenRequest struct {
+	Name string `json:"name"`
+}
+
+// CreatedToken is an API token together with its secret, returned only
+// when the token is created
+type CreatedToken struct {
+	AuthToken
+	Token string `json:"token"`
+}
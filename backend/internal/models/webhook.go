@@ -0,0 +1,92 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook sends new posts to a URL. FeedID and Category optionally narrow
+// it to one feed or category of the owner's subscriptions.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	FeedID    *int64    `json:"feed_id"`
+	Category  *string   `json:"category"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Secret is only returned when the webhook is created or its secret
+	// is replaced
+	Secret string `json:"secret,omitempty"`
+}
+
+type CreateWebhookRequest struct {
+	URL      string  `json:"url"`
+	Secret   string  `json:"secret"`
+	FeedID   *int64  `json:"feed_id"`
+	Category *string `json:"category"`
+}
+
+// UpdateWebhookRequest changes the fields that are set. A FeedID of 0 or
+// an empty Category removes that filter.
+type UpdateWebhookRequest struct {
+	URL      *string `json:"url"`
+	Secret   *string `json:"secret"`
+	FeedID   *int64  `json:"feed_id"`
+	Category *string `json:"category"`
+	IsActive *bool   `json:"is_active"`
+}
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook events
+const (
+	WebhookEventPostCreated = "post.created"
+)
+
+// WebhookDelivery is one queued or attempted call of a webhook
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	PostID         *int64          `json:"post_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// WebhookFeed identifies the feed of a post in a webhook payload
+type WebhookFeed struct {
+	ID       int64   `json:"id"`
+	Name     string  `json:"name"`
+	URL      string  `json:"url"`
+	Category *string `json:"category"`
+}
+
+// WebhookPayload is the JSON body POSTed to a webhook
+type WebhookPayload struct {
+	Event string      `json:"event"`
+	Feed  WebhookFeed `json:"feed"`
+	Post  Post        `json:"post"`
+}
+
+// PendingDelivery is a delivery that is due, with what is needed to send
+// it. It is internal to the dispatcher and never serialised.
+type PendingDelivery struct {
+	ID       int64
+	Event    string
+	Payload  []byte
+	Attempts int
+	URL      string
+	Secret   string
+}
@@ -12,7 +12,9 @@ import (
 func New(h *handlers.Handler, allowedOrigins []string) *chi.Mux {
 	r := chi.NewRouter()
 
-	// Middleware
+	// Middleware; only the event stream, read by EventSource, takes its
+	// token from the query string
+	r.Use(handlers.TokenFromQuery("/api/events"))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
@@ -36,33 +38,106 @@ func New(h *handlers.Handler, allowedOrigins []string) *chi.Mux {
 
 	// API routes
 	r.Route("/api", func(r chi.Router) {
-		// Feed routes
-		r.Route("/feeds", func(r chi.Router) {
-			r.Get("/", h.GetAllFeeds)
-			r.Post("/", h.CreateFeed)
-			r.Post("/reddit", h.CreateRedditFeed)
-			r.Post("/refresh", h.RefreshAllFeeds)
-
-			r.Route("/{id}", func(r chi.Router) {
-				r.Get("/", h.GetFeedByID)
-				r.Put("/", h.UpdateFeed)
-				r.Delete("/", h.DeleteFeed)
-				r.Post("/refresh", h.RefreshFeed)
-			})
+		r.Post("/auth/login", h.Login)
+
+		// Proxied images are authorised by their signature, as <img> tags
+		// cannot send a token
+		r.Get("/media/proxy", h.ProxyMedia)
+
+		// Everything else needs a session or API token
+		r.Group(func(r chi.Router) {
+			r.Use(h.Authenticate)
+			authenticatedRoutes(r, h)
 		})
+	})
+
+	return r
+}
 
-		// Post routes
-		r.Route("/posts", func(r chi.Router) {
-			r.Get("/", h.GetAllPosts)
-			r.Delete("/", h.DeleteAllPosts)
+func authenticatedRoutes(r chi.Router, h *handlers.Handler) {
+	// Auth routes
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/logout", h.Logout)
+		r.Get("/me", h.GetCurrentUser)
+		r.Put("/password", h.ChangePassword)
+		r.Get("/tokens", h.GetTokens)
+		r.Post("/tokens", h.CreateToken)
+		r.Delete("/tokens/{id}", h.DeleteToken)
+	})
+
+	// User administration
+	r.Route("/users", func(r chi.Router) {
+		r.Use(h.RequireAdmin)
+		r.Get("/", h.GetUsers)
+		r.Post("/", h.CreateUser)
+		r.Delete("/{id}", h.DeleteUser)
+	})
 
-			r.Get("/feed/{feedId}", h.GetPostsByFeed)
+	// Feed routes
+	r.Route("/feeds", func(r chi.Router) {
+		r.Get("/", h.GetAllFeeds)
+		r.Post("/", h.CreateFeed)
+		r.Post("/reddit", h.CreateRedditFeed)
+		r.Post("/discover", h.DiscoverFeeds)
+		r.Post("/refresh", h.RefreshAllFeeds)
+		r.Post("/import/opml", h.ImportOPML)
+		r.Get("/export/opml", h.ExportOPML)
 
-			r.Route("/{id}", func(r chi.Router) {
-				r.Patch("/read", h.MarkPostRead)
-			})
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", h.GetFeedByID)
+			r.Put("/", h.UpdateFeed)
+			r.Delete("/", h.DeleteFeed)
+			r.Post("/refresh", h.RefreshFeed)
 		})
 	})
 
-	return r
+	// Webhook routes
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Get("/", h.GetWebhooks)
+		r.Post("/", h.CreateWebhook)
+
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", h.GetWebhook)
+			r.Put("/", h.UpdateWebhook)
+			r.Delete("/", h.DeleteWebhook)
+			r.Get("/deliveries", h.GetWebhookDeliveries)
+		})
+	})
+
+	// Filter rule routes
+	r.Route("/filters", func(r chi.Router) {
+		r.Get("/", h.GetFilterRules)
+		r.Post("/", h.CreateFilterRule)
+		r.Post("/dry-run", h.DryRunNewFilterRule)
+
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", h.GetFilterRule)
+			r.Put("/", h.UpdateFilterRule)
+			r.Delete("/", h.DeleteFilterRule)
+			r.Get("/dry-run", h.DryRunFilterRule)
+		})
+	})
+
+	// Event stream
+	r.Get("/events", h.StreamEvents)
+
+	// Category routes
+	r.Get("/categories", h.GetCategories)
+
+	// Post routes
+	r.Route("/posts", func(r chi.Router) {
+		r.Get("/", h.GetAllPosts)
+		r.With(h.RequireAdmin).Delete("/", h.DeleteAllPosts)
+		r.Get("/search", h.SearchPosts)
+		r.Get("/starred", h.GetStarredPosts)
+		r.Post("/read", h.MarkPostsRead)
+		r.Post("/read/batch", h.BatchMarkPostsRead)
+
+		r.Get("/feed/{feedId}", h.GetPostsByFeed)
+
+		r.Route("/{id}", func(r chi.Router) {
+			r.Patch("/read", h.MarkPostRead)
+			r.Patch("/star", h.StarPost)
+		})
+	})
 }
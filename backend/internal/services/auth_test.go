@@ -0,0 +1,86 @@
+package services
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") {
+		t.Errorf("hash = %q, want the pbkdf2-sha256 scheme and work factor", hash)
+	}
+
+	if ok, err := CheckPassword("correct horse", hash); !ok || err != nil {
+		t.Errorf("CheckPassword with the right password = %v, %v", ok, err)
+	}
+	if ok, err := CheckPassword("correct horse ", hash); ok || err != nil {
+		t.Errorf("CheckPassword with the wrong password = %v, %v", ok, err)
+	}
+
+	again, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hash {
+		t.Error("hashing the same password twice gave the same hash; salts should differ")
+	}
+}
+
+func TestCheckPasswordKeepsWorkFactor(t *testing.T) {
+	// Hashes made with an older work factor must keep verifying
+	salt := []byte("0123456789abcdef")
+	key, err := pbkdf2.Key(sha256.New, "hunter22", salt, 1000, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := "pbkdf2-sha256$1000$" + base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(key)
+
+	if ok, err := CheckPassword("hunter22", hash); !ok || err != nil {
+		t.Errorf("CheckPassword = %v, %v", ok, err)
+	}
+}
+
+func TestCheckPasswordRejectsInvalidHashes(t *testing.T) {
+	hashes := []string{
+		"",
+		"plaintext",
+		"bcrypt$10$c2FsdA$a2V5",
+		"pbkdf2-sha256$0$c2FsdA$a2V5",
+		"pbkdf2-sha256$many$c2FsdA$a2V5",
+		"pbkdf2-sha256$1000$!!!$a2V5",
+		"pbkdf2-sha256$1000$c2FsdA$",
+		"pbkdf2-sha256$1000$c2FsdA",
+	}
+	for _, hash := range hashes {
+		if ok, err := CheckPassword("password", hash); ok || !errors.Is(err, ErrInvalidPasswordHash) {
+			t.Errorf("CheckPassword(%q) = %v, %v; want %v", hash, ok, err, ErrInvalidPasswordHash)
+		}
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	token, hash, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != HashToken(token) || hash == token {
+		t.Errorf("GenerateToken returned hash %q for token %q", hash, token)
+	}
+
+	other, _, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token {
+		t.Error("GenerateToken returned the same token twice")
+	}
+}
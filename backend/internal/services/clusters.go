@@ -0,0 +1,260 @@
+package services
+
+import (
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+const (
+	// maxTitleDistance is the most bits two title hashes may differ by for
+	// their posts to be taken as the same story
+	maxTitleDistance = 3
+	// minTitleWords is the least number of significant words a title needs
+	// to be hashed; shorter titles match too much
+	minTitleWords = 3
+	// clusterBatchSize is how many stored posts are clustered at a time
+	clusterBatchSize = 500
+)
+
+// trackingParams are query parameters that identify where a reader came
+// from rather than what they are reading. Any parameter starting with
+// "utm_" is dropped as well.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "_hsenc": true, "_hsmi": true,
+	"ref": true, "ref_src": true, "ref_url": true, "cmpid": true, "ncid": true,
+	"ocid": true, "sr_share": true, "share": true, "smid": true, "cid": true,
+	"soc_src": true, "soc_trk": true, "wt_mc": true, "__twitter_impression": true,
+}
+
+// titleStopwords are left out of title hashes, so headlines that differ
+// only in filler words still match
+var titleStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "has": true, "have": true,
+	"how": true, "in": true, "is": true, "it": true, "its": true, "of": true,
+	"on": true, "or": true, "that": true, "the": true, "this": true, "to": true,
+	"was": true, "what": true, "why": true, "will": true, "with": true,
+}
+
+// URLKey normalises a link so that the addresses of one article compare
+// equal: the scheme, a leading "www.", default ports, fragments, tracking
+// parameters and trailing slashes are dropped, the host is lowercased and
+// the remaining query parameters are sorted. It returns "" for anything
+// that is not an http(s) URL.
+func URLKey(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "utm_") || trackingParams[lower] {
+			query.Del(name)
+		}
+	}
+
+	key := host + strings.TrimRight(u.EscapedPath(), "/")
+	if len(query) > 0 {
+		// Encode sorts by name
+		key += "?" + query.Encode()
+	}
+	return key
+}
+
+// TitleSimHash returns a 64-bit SimHash of a title's significant words, so
+// that near-identical headlines give hashes a few bits apart. Titles with
+// fewer than minTitleWords significant words hash to 0, which never
+// matches.
+func TitleSimHash(title string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var weights [64]int
+	count := 0
+	for _, word := range words {
+		if titleStopwords[word] {
+			continue
+		}
+		count++
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	if count < minTitleWords {
+		return 0
+	}
+
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+// ClusterOptions controls how posts are grouped into stories
+type ClusterOptions struct {
+	// Window is how far apart in fetch time two posts may be and still be
+	// the same story
+	Window time.Duration
+	// ResolveLinks follows each new post's link, through redirects and the
+	// page's canonical link, to find the article's real address
+	ResolveLinks bool
+}
+
+// StoryClusterer groups posts from different feeds that carry the same
+// story: posts whose links lead to the same article, or whose titles are
+// near-identical. A story's cluster ID is the ID of its first post.
+type StoryClusterer struct {
+	db   *database.DB
+	opts ClusterOptions
+}
+
+// NewStoryClusterer creates a clusterer
+func NewStoryClusterer(db *database.DB, opts ClusterOptions) *StoryClusterer {
+	return &StoryClusterer{db: db, opts: opts}
+}
+
+// Sign fills in the URL key and title hash of a post that is about to be
+// stored
+func (c *StoryClusterer) Sign(post *models.Post) {
+	post.URLKey = URLKey(post.Link)
+	post.TitleHash = TitleSimHash(post.Title)
+}
+
+// ResolvesLinks reports whether new posts' links should be queued to be
+// followed to their canonical address
+func (c *StoryClusterer) ResolvesLinks() bool {
+	return c.opts.ResolveLinks
+}
+
+// Assign adds a stored post to the story it belongs to, if one of the
+// posts around it carries the same story, and returns its cluster ID. A
+// post with the same link joins that story; failing that, the post with
+// the closest title does. Posts matching several stories join them up.
+func (c *StoryClusterer) Assign(postID int64) (int64, error) {
+	sig, err := c.db.GetStorySignature(postID)
+	if err != nil {
+		return 0, err
+	}
+
+	matches, err := c.db.FindStoryMatches(sig, c.opts.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	var clusters []int64
+	best, bestDistance := int64(0), maxTitleDistance+1
+	for _, match := range matches {
+		if sig.URLKey != "" && match.URLKey == sig.URLKey {
+			clusters = append(clusters, match.ClusterID)
+			continue
+		}
+		if match.FeedID == sig.FeedID {
+			continue
+		}
+		if distance := bits.OnesCount64(sig.TitleHash ^ match.TitleHash); distance < bestDistance {
+			best, bestDistance = match.ClusterID, distance
+		}
+	}
+	if len(clusters) == 0 && best != 0 {
+		clusters = append(clusters, best)
+	}
+
+	cluster := sig.ClusterID
+	for _, other := range clusters {
+		if err := c.db.MergeStoryClusters(cluster, other); err != nil {
+			return 0, err
+		}
+		cluster = min(cluster, other)
+	}
+	return cluster, nil
+}
+
+// ResolveURL records the address a post's link was found to lead to, and
+// re-clusters the post if that changes its URL key
+func (c *StoryClusterer) ResolveURL(postID int64, resolved string) error {
+	sig, err := c.db.GetStorySignature(postID)
+	if err != nil {
+		return err
+	}
+
+	key := URLKey(resolved)
+	if key == "" {
+		key = sig.URLKey
+	}
+	if err := c.db.SetPostURLKey(postID, key); err != nil {
+		return err
+	}
+	if key == sig.URLKey {
+		return nil
+	}
+
+	_, err = c.Assign(postID)
+	return err
+}
+
+// ClusterStoredPosts signs and clusters the posts stored before stories
+// were clustered, oldest first. Posts that cannot be signed are skipped.
+func (c *StoryClusterer) ClusterStoredPosts() {
+	var afterID int64
+	clustered := 0
+	for {
+		posts, err := c.db.GetUnsignedPosts(afterID, clusterBatchSize)
+		if err != nil {
+			log.Printf("Error loading posts to cluster: %v", err)
+			return
+		}
+
+		for i := range posts {
+			post := &posts[i]
+			afterID = post.ID
+			c.Sign(post)
+			if post.URLKey == "" && post.TitleHash == 0 {
+				continue
+			}
+			if err := c.db.SetStorySignature(post.ID, post.URLKey, post.TitleHash); err != nil {
+				log.Printf("Error storing story signature: %v", err)
+				return
+			}
+			if _, err := c.Assign(post.ID); err != nil {
+				log.Printf("Error clustering post %d: %v", post.ID, err)
+				return
+			}
+			clustered++
+		}
+
+		if len(posts) < clusterBatchSize {
+			break
+		}
+	}
+
+	if clustered > 0 {
+		log.Printf("Clustered %d stored posts into stories", clustered)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"math/bits"
+	"testing"
+)
+
+func TestURLKey(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://www.Example.com/story/", "example.com/story"},
+		{"http://example.com:80/story#comments", "example.com/story"},
+		{"https://example.com:443/story", "example.com/story"},
+		{"https://example.com:8443/story", "example.com:8443/story"},
+		{"https://example.com/story?utm_source=rss&utm_medium=feed&fbclid=abc", "example.com/story"},
+		{"https://example.com/story?b=2&UTM_Campaign=x&a=1", "example.com/story?a=1&b=2"},
+		{"https://example.com/", "example.com"},
+		{"  https://example.com/a%20b  ", "example.com/a%20b"},
+		{"ftp://example.com/story", ""},
+		{"/story", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := URLKey(tt.in); got != tt.want {
+			t.Errorf("URLKey(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// The case of the path matters
+	if URLKey("https://example.com/Story") == URLKey("https://example.com/story") {
+		t.Error("URLKey ignored the case of the path")
+	}
+}
+
+func TestTitleSimHash(t *testing.T) {
+	distance := func(a, b string) int {
+		return bits.OnesCount64(TitleSimHash(a) ^ TitleSimHash(b))
+	}
+
+	same := [][2]string{
+		{"Apple announces new MacBook Pro lineup", "Apple Announces New MacBook Pro Lineup!"},
+		{"Apple announces new MacBook Pro lineup", "Apple announces the new MacBook Pro lineup"},
+		{"Apple announces new MacBook Pro lineup", "Why Apple announces a new MacBook Pro lineup"},
+	}
+	for _, pair := range same {
+		if d := distance(pair[0], pair[1]); d > maxTitleDistance {
+			t.Errorf("%q and %q are %d bits apart, want at most %d", pair[0], pair[1], d, maxTitleDistance)
+		}
+	}
+
+	different := [][2]string{
+		{"Apple announces new MacBook Pro lineup", "Senate passes sweeping infrastructure bill"},
+		{"Rust 2.0 roadmap published by core team", "Python 4 will not happen, says Guido"},
+	}
+	for _, pair := range different {
+		if d := distance(pair[0], pair[1]); d <= maxTitleDistance {
+			t.Errorf("%q and %q are only %d bits apart", pair[0], pair[1], d)
+		}
+	}
+
+	for _, short := range []string{"", "Update", "The big news", "Is it over?"} {
+		if h := TitleSimHash(short); h != 0 {
+			t.Errorf("TitleSimHash(%q) = %x, want 0 for too few significant words", short, h)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned when a user-supplied URL resolves to a
+// local or private address
+var errPrivateAddress = errors.New("refusing to connect to a private address")
+
+// newPublicTransport returns a transport for requests to URLs taken from
+// feeds and users. Unless allowPrivate is set it refuses to connect to the
+// server's own network, wherever DNS or redirects lead.
+func newPublicTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = refusePrivateAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// refusePrivateAddress is a dialer hook that refuses connections to
+// non-public addresses
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// isPrivateIP reports whether ip is loopback, private, link-local,
+// multicast or unspecified
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast()
+}
+
+// isPrivateHost reports whether a URL host names the local machine or is a
+// non-public IP address. Names are not resolved; the dialer checks those.
+func isPrivateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && isPrivateIP(ip)
+}
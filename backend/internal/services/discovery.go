@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+// maxDiscoveryBody limits how much of a page or candidate feed is read
+const maxDiscoveryBody = 5 << 20
+
+// ErrInvalidURL is returned by DiscoverFeeds for anything but an absolute
+// http(s) URL
+var ErrInvalidURL = errors.New("invalid URL")
+
+// ErrFetchFailed wraps errors reaching a URL during discovery
+var ErrFetchFailed = errors.New("failed to fetch URL")
+
+// ErrNoFeedsFound is returned by DiscoverFeeds when a page neither is a feed
+// nor links to one
+var ErrNoFeedsFound = errors.New("no feeds found")
+
+// feedLinkTypes are the <link rel="alternate"> types that advertise a feed
+var feedLinkTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+}
+
+// commonFeedPaths are probed when a page does not advertise any feeds
+var commonFeedPaths = []string{"/feed", "/rss", "/rss.xml", "/atom.xml", "/feed.xml", "/index.xml"}
+
+// FeedCandidate is a feed found by DiscoverFeeds
+type FeedCandidate struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+// discoveredFeed is a working feed found by discover, with its parsed
+// contents
+type discoveredFeed struct {
+	URL  string
+	Feed *gofeed.Feed
+}
+
+// DiscoverFeeds finds the feeds behind a URL. A URL that is itself a feed
+// yields just that feed. Otherwise the page's <link rel="alternate"> tags
+// are followed, falling back to probing common feed paths on its host.
+// Every candidate is fetched and parsed, so only working feeds are
+// returned.
+func (f *FeedFetcher) DiscoverFeeds(rawURL string) ([]FeedCandidate, error) {
+	found, err := f.discover(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]FeedCandidate, len(found))
+	for i, d := range found {
+		candidates[i] = newFeedCandidate(d.URL, d.Feed)
+	}
+	return candidates, nil
+}
+
+func (f *FeedFetcher) discover(rawURL string) ([]discoveredFeed, error) {
+	if !IsHTTPURL(rawURL) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, rawURL)
+	}
+
+	body, pageURL, err := f.fetchDocument(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if feed, err := newParser().Parse(bytes.NewReader(body)); err == nil {
+		return []discoveredFeed{{URL: rawURL, Feed: feed}}, nil
+	}
+
+	candidateURLs := feedLinks(body, pageURL)
+	if len(candidateURLs) == 0 {
+		for _, path := range commonFeedPaths {
+			candidateURLs = append(candidateURLs, pageURL.ResolveReference(&url.URL{Path: path}).String())
+		}
+	}
+
+	var found []discoveredFeed
+	seen := map[string]bool{}
+	for _, candidateURL := range candidateURLs {
+		if seen[candidateURL] {
+			continue
+		}
+		seen[candidateURL] = true
+
+		body, feedURL, err := f.fetchDocument(candidateURL)
+		if err != nil {
+			continue
+		}
+		feed, err := newParser().Parse(bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		// Probed paths often redirect, so the same feed can turn up twice
+		if feedURL.String() != candidateURL {
+			if seen[feedURL.String()] {
+				continue
+			}
+			seen[feedURL.String()] = true
+		}
+		found = append(found, discoveredFeed{URL: feedURL.String(), Feed: feed})
+	}
+
+	if len(found) == 0 {
+		return nil, ErrNoFeedsFound
+	}
+
+	return found, nil
+}
+
+func newFeedCandidate(feedURL string, feed *gofeed.Feed) FeedCandidate {
+	title := strings.TrimSpace(feed.Title)
+	if title == "" {
+		title = feedURL
+	}
+	return FeedCandidate{URL: feedURL, Title: title, Type: feed.FeedType}
+}
+
+// fetchDocument GETs a URL and returns its body along with the final URL
+// after any redirects
+func (f *FeedFetcher) fetchDocument(rawURL string) ([]byte, *url.URL, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	release := f.acquireHost(req.URL)
+	defer release()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("%w: %w", ErrFetchFailed,
+			gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	limit := int64(maxDiscoveryBody)
+	if f.opts.MaxFeedSize > 0 {
+		limit = min(limit, f.opts.MaxFeedSize)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+
+	return body, resp.Request.URL, nil
+}
+
+// feedLinks returns the absolute URLs of the feeds an HTML page advertises
+// with <link rel="alternate"> tags, honouring any <base href>
+func feedLinks(page []byte, pageURL *url.URL) []string {
+	base := pageURL
+	var links []string
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := tokenizer.Token()
+		attrs := map[string]string{}
+		for _, attr := range token.Attr {
+			attrs[strings.ToLower(attr.Key)] = strings.TrimSpace(attr.Val)
+		}
+
+		switch token.Data {
+		case "base":
+			if href, err := pageURL.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+				base = href
+			}
+		case "link":
+			if !hasToken(attrs["rel"], "alternate") || !feedLinkTypes[strings.ToLower(attrs["type"])] {
+				continue
+			}
+			if href, err := base.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+				links = append(links, href.String())
+			}
+		}
+	}
+}
+
+// hasToken reports whether a space-separated attribute value such as rel
+// contains the given token, ignoring case
+func hasToken(value, token string) bool {
+	for _, field := range strings.Fields(value) {
+		if strings.EqualFold(field, token) {
+			return true
+		}
+	}
+	return false
+}
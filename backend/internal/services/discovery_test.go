@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestFeedLinks(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/blog/post.html")
+
+	tests := []struct {
+		name string
+		page string
+		want []string
+	}{
+		{
+			"relative and absolute",
+			`<link rel="alternate" type="application/rss+xml" href="feed.xml">
+			 <link rel="alternate" type="application/atom+xml" href="https://other.example/atom">`,
+			[]string{"https://example.com/blog/feed.xml", "https://other.example/atom"},
+		},
+		{
+			"JSON feed and case-insensitive attributes",
+			`<LINK REL="Alternate Home" TYPE="Application/Feed+JSON" HREF="/feed.json" />`,
+			[]string{"https://example.com/feed.json"},
+		},
+		{
+			"base href",
+			`<head><base href="https://cdn.example.com/site/"><link rel="alternate" type="application/rss+xml" href="rss"></head>`,
+			[]string{"https://cdn.example.com/site/rss"},
+		},
+		{
+			"not feeds",
+			`<link rel="stylesheet" type="text/css" href="a.css">
+			 <link rel="alternate" hreflang="fr" href="/fr/">
+			 <link rel="alternate" type="application/rss+xml" href="">
+			 <a rel="alternate" type="application/rss+xml" href="/feed">feed</a>`,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		if got := feedLinks([]byte(tt.page), pageURL); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: feedLinks = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoverFeeds(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head>
+			<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+			<link rel="alternate" type="application/rss+xml" href="/old-feed">
+			<link rel="alternate" type="application/atom+xml" href="/missing.xml">
+		</head></html>`)
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testRSS)
+	})
+	mux.Handle("/old-feed", http.RedirectHandler("/feed.xml", http.StatusMovedPermanently))
+	mux.HandleFunc("/missing.xml", http.NotFound)
+	mux.HandleFunc("/bare/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body>No feed links here</body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := newTestFetcher(nil, FetcherOptions{})
+
+	found, err := fetcher.DiscoverFeeds(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].URL != server.URL+"/feed.xml" || found[0].Title != "Test" || found[0].Type != "rss" {
+		t.Errorf("discovering from a page found %+v", found)
+	}
+
+	found, err = fetcher.DiscoverFeeds(server.URL + "/feed.xml")
+	if err != nil || len(found) != 1 || found[0].URL != server.URL+"/feed.xml" {
+		t.Errorf("discovering a feed URL found %+v, %v", found, err)
+	}
+
+	// Without feed links, common paths on the host are probed
+	found, err = fetcher.DiscoverFeeds(server.URL + "/bare/")
+	if err != nil || len(found) != 1 || found[0].URL != server.URL+"/feed.xml" {
+		t.Errorf("probing common paths found %+v, %v", found, err)
+	}
+
+	if _, err := fetcher.DiscoverFeeds("ftp://example.com/"); !errors.Is(err, ErrInvalidURL) {
+		t.Errorf("discovering an ftp URL = %v, want %v", err, ErrInvalidURL)
+	}
+	if _, err := fetcher.DiscoverFeeds(server.URL + "/missing.xml"); !errors.Is(err, ErrFetchFailed) {
+		t.Errorf("discovering a missing page = %v, want %v", err, ErrFetchFailed)
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types published on the hub
+const (
+	// EventPostsCreated carries a models.NewPostsEvent
+	EventPostsCreated = "posts.created"
+	// EventFeedError carries a models.FeedStatusEvent after a failed fetch
+	EventFeedError = "feed.error"
+	// EventFeedRecovered carries a models.FeedStatusEvent once a failing
+	// feed fetches successfully again
+	EventFeedRecovered = "feed.recovered"
+	// EventCountsUpdated carries a user's []models.FeedCounts after their
+	// read state changes
+	EventCountsUpdated = "counts.updated"
+	// EventResync tells a resuming client that events were missed and it
+	// should reload its state
+	EventResync = "resync"
+)
+
+const (
+	// eventHistorySize is how many recent events are kept for clients
+	// resuming with Last-Event-ID
+	eventHistorySize = 1024
+	// subscriberBuffer is how many events may queue for a subscriber before
+	// it is dropped as too slow
+	subscriberBuffer = 64
+)
+
+// Event is a message published on the hub. UserID and FeedID scope who may
+// see it: an event for a user is only delivered to them, and an event for
+// a feed only to its subscribers. Zero means unscoped.
+type Event struct {
+	ID     int64
+	Type   string
+	UserID int64
+	FeedID int64
+	Data   interface{}
+}
+
+// EventHub is an in-process pub/sub hub. It numbers events and keeps a
+// short history so subscribers can resume after a disconnect.
+type EventHub struct {
+	mu          sync.Mutex
+	nextID      int64
+	history     []Event
+	subscribers map[*EventSubscription]struct{}
+	closed      bool
+}
+
+// EventSubscription receives the events published after it was created.
+// Events is closed when the hub shuts down or the subscriber falls too far
+// behind; in the latter case it can resubscribe from its last event ID.
+type EventSubscription struct {
+	Events <-chan Event
+	// Replay holds the missed events when resuming from a last event ID
+	Replay []Event
+	// Resync is set when the missed events are no longer available
+	Resync bool
+
+	hub    *EventHub
+	events chan Event
+}
+
+// NewEventHub creates a hub. Event IDs start from the current time in
+// milliseconds, so IDs issued before a restart are older than any issued
+// after it and resuming clients are told to resync.
+func NewEventHub() *EventHub {
+	return &EventHub{
+		nextID:      time.Now().UnixMilli(),
+		subscribers: make(map[*EventSubscription]struct{}),
+	}
+}
+
+// Publish sends an event to every subscriber. A nil hub discards events.
+func (h *EventHub) Publish(eventType string, userID, feedID int64, data interface{}) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.nextID++
+	event := Event{ID: h.nextID, Type: eventType, UserID: userID, FeedID: feedID, Data: data}
+
+	if len(h.history) == eventHistorySize {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:eventHistorySize-1]
+	}
+	h.history = append(h.history, event)
+
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Dropping slow event subscriber")
+			h.remove(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber. With a lastEventID the events
+// published after it are returned in Replay, or Resync is set when they
+// have already left the history.
+func (h *EventHub) Subscribe(lastEventID int64) *EventSubscription {
+	events := make(chan Event, subscriberBuffer)
+	sub := &EventSubscription{Events: events, hub: h, events: events}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(events)
+		return sub
+	}
+
+	if lastEventID > 0 && lastEventID < h.nextID {
+		if len(h.history) == 0 || h.history[0].ID > lastEventID+1 {
+			sub.Resync = true
+		} else {
+			for _, event := range h.history {
+				if event.ID > lastEventID {
+					sub.Replay = append(sub.Replay, event)
+				}
+			}
+		}
+	} else if lastEventID > h.nextID {
+		// Issued by a hub that has since restarted
+		sub.Resync = true
+	}
+
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Close unsubscribes, closing Events if it is still open
+func (s *EventSubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.subscribers[s]; ok {
+		s.hub.remove(s)
+	}
+}
+
+// remove drops a subscriber; the caller must hold mu
+func (h *EventHub) remove(sub *EventSubscription) {
+	delete(h.subscribers, sub)
+	close(sub.events)
+}
+
+// Close shuts the hub down, ending every subscription so that streaming
+// responses return. Later events are discarded.
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for sub := range h.subscribers {
+		h.remove(sub)
+	}
+	log.Println("Event hub stopped")
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// extractionBatchSize is how many due extractions are loaded at a time
+	extractionBatchSize = 50
+	// maxArticleBody limits how much of an article page is read
+	maxArticleBody = 5 << 20
+	// maxTrackedHosts is how many hosts are remembered for pacing before
+	// idle ones are forgotten
+	maxTrackedHosts = 1000
+)
+
+// errPermanent marks extraction failures that retrying will not fix
+var errPermanent = errors.New("permanent failure")
+
+// ExtractorOptions controls how queued full-text extractions are paced.
+// Extraction runs apart from feed polling, so slow article sites never
+// hold up fetching feeds.
+type ExtractorOptions struct {
+	// Interval is how often the queue is checked for due retries; new
+	// posts are extracted straight away. A non-positive interval disables
+	// extraction.
+	Interval time.Duration
+	// Workers is the number of articles fetched in parallel
+	Workers int
+	// HostDelay is the least time between two requests to the same host
+	HostDelay time.Duration
+	// Timeout bounds each article request
+	Timeout time.Duration
+	// MaxAttempts is how many times an extraction is tried before it is
+	// marked failed
+	MaxAttempts int
+	// BackoffBase is the delay before the first retry; it doubles for
+	// every further failure
+	BackoffBase time.Duration
+	// BackoffMax caps the retry delay
+	BackoffMax time.Duration
+	// AllowPrivate lets article pages be fetched from the server's own
+	// network. Links come from feeds, so this matters only where feeds may
+	// point at self-hosted sites.
+	AllowPrivate bool
+}
+
+// ContentExtractor downloads the article pages of queued posts and stores
+// their main content, for feeds that fetch full text, their Open Graph or
+// Twitter card image, for posts without a lead image, and their canonical
+// address, for story clustering. The queue is persisted, so extractions
+// still pending at shutdown run after the next start.
+type ContentExtractor struct {
+	db       *database.DB
+	events   *EventHub
+	clusters *StoryClusterer
+	client   *http.Client
+	opts     ExtractorOptions
+	queue    *workQueue[models.PendingExtraction]
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	hostMu   sync.Mutex
+	hostNext map[string]time.Time
+}
+
+// NewContentExtractor creates an extractor that is woken by the
+// posts.created events on the hub and reports resolved links to clusters
+func NewContentExtractor(db *database.DB, events *EventHub, clusters *StoryClusterer, opts ExtractorOptions) *ContentExtractor {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &ContentExtractor{
+		db:       db,
+		events:   events,
+		clusters: clusters,
+		client:   &http.Client{Timeout: opts.Timeout, Transport: newPublicTransport(opts.AllowPrivate)},
+		opts:     opts,
+		ctx:      ctx,
+		cancel:   cancel,
+		hostNext: make(map[string]time.Time),
+	}
+	e.queue = &workQueue[models.PendingExtraction]{
+		name:      "Content extractor",
+		jobs:      "content extractions",
+		interval:  opts.Interval,
+		workers:   opts.Workers,
+		batchSize: extractionBatchSize,
+		events:    events,
+		load:      db.GetDueExtractions,
+		run:       e.run,
+		ctx:       ctx,
+	}
+	return e
+}
+
+// Start begins working through the queue
+func (e *ContentExtractor) Start() {
+	if e.opts.Interval <= 0 {
+		log.Println("Content extractor disabled")
+		return
+	}
+
+	log.Printf("Starting content extractor with interval: %v (%d workers, %v between requests to a host)",
+		e.opts.Interval, e.opts.Workers, e.opts.HostDelay)
+	e.queue.start()
+}
+
+// Stop stops the extractor, abandoning requests in flight; they are
+// retried after the next start
+func (e *ContentExtractor) Stop() {
+	log.Println("Stopping content extractor...")
+	e.cancel()
+}
+
+// run makes one attempt at an extraction and records the outcome
+func (e *ContentExtractor) run(job models.PendingExtraction) {
+	doc, pageURL, err := e.fetchPage(job.Link)
+	if e.ctx.Err() != nil {
+		// Interrupted by shutdown; not the site's fault
+		return
+	}
+
+	if err == nil {
+		// Images are read first, as extracting the article strips the
+		// page's meta tags
+		if job.Image {
+			e.storePageImage(job, doc, pageURL)
+		}
+		if job.ResolveURL {
+			if err := e.clusters.ResolveURL(job.PostID, canonicalURL(doc, pageURL)); err != nil {
+				log.Printf("Error storing resolved link: %v", err)
+			}
+		}
+		if !job.FullText {
+			if err := e.db.CompleteExtraction(job.PostID); err != nil {
+				log.Printf("Error recording content extraction: %v", err)
+			}
+			return
+		}
+
+		var content string
+		if content, err = e.extract(doc, pageURL); err == nil {
+			if err := e.db.RecordExtractionSuccess(job.PostID, content, PlainText(content)); err != nil {
+				log.Printf("Error storing extracted content: %v", err)
+			}
+			return
+		}
+	}
+
+	var retryAt *time.Time
+	if attempts := job.Attempts + 1; attempts < e.opts.MaxAttempts && !errors.Is(err, errPermanent) {
+		at := time.Now().Add(backoffDelay(e.opts.BackoffBase, e.opts.BackoffMax, attempts))
+		retryAt = &at
+	} else {
+		log.Printf("Extracting %s failed: %v", job.Link, err)
+	}
+
+	if err := e.db.RecordExtractionFailure(job.PostID, err.Error(), retryAt); err != nil {
+		log.Printf("Error recording content extraction: %v", err)
+	}
+}
+
+// storePageImage gives a post the lead image named in its page's meta
+// tags, if there is one
+func (e *ContentExtractor) storePageImage(job models.PendingExtraction, doc *goquery.Document, pageURL *url.URL) {
+	image, source := FindLeadImage(ImageSource{Page: doc, BaseURL: pageURL}, PageImageStrategies)
+	if image == "" {
+		return
+	}
+	if err := e.db.RecordPageImage(job.PostID, image, source); err != nil {
+		log.Printf("Error storing page image: %v", err)
+	}
+}
+
+// canonicalURL returns the address an article page names as its canonical
+// one, or the address it was served from if it names none
+func canonicalURL(doc *goquery.Document, pageURL *url.URL) string {
+	href := strings.TrimSpace(doc.Find(`link[rel~="canonical"][href]`).First().AttrOr("href", ""))
+	if u, err := url.Parse(href); err == nil && href != "" {
+		if u = pageURL.ResolveReference(u); u.Scheme == "http" || u.Scheme == "https" {
+			return u.String()
+		}
+	}
+	return pageURL.String()
+}
+
+// fetchPage downloads and parses an article page, returning it with the
+// URL it was served from after redirects. Errors wrapping errPermanent are
+// not worth retrying.
+func (e *ContentExtractor) fetchPage(link string) (*goquery.Document, *url.URL, error) {
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	if !e.waitForHost(req.URL) {
+		return nil, nil, e.ctx.Err()
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("server returned %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			err = fmt.Errorf("%w: %w", errPermanent, err)
+		}
+		return nil, nil, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); contentType != "" &&
+		(err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml")) {
+		return nil, nil, fmt.Errorf("%w: not an HTML page (%s)", errPermanent, contentType)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxArticleBody), contentType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errPermanent, err)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, resp.Request.URL, nil
+}
+
+// extract returns the main content of an article page, sanitized
+func (e *ContentExtractor) extract(doc *goquery.Document, pageURL *url.URL) (string, error) {
+	content, err := extractArticle(doc, pageURL)
+	if errors.Is(err, ErrNoArticle) {
+		return "", fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return SanitizeHTML(content, pageURL), nil
+}
+
+// waitForHost blocks until a request to the URL's host is allowed,
+// reserving the next slot for it. It returns false if the extractor is
+// stopped while waiting.
+func (e *ContentExtractor) waitForHost(u *url.URL) bool {
+	e.hostMu.Lock()
+	now := time.Now()
+	// Forget hosts whose slots have passed so the map does not grow
+	// with every site ever linked to
+	if len(e.hostNext) > maxTrackedHosts {
+		for host, next := range e.hostNext {
+			if next.Before(now) {
+				delete(e.hostNext, host)
+			}
+		}
+	}
+	at := e.hostNext[u.Host]
+	if at.Before(now) {
+		at = now
+	}
+	e.hostNext[u.Host] = at.Add(e.opts.HostDelay)
+	e.hostMu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-e.ctx.Done():
+		return false
+	}
+}
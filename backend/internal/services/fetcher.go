@@ -1,39 +1,249 @@
 package services
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
-	"github.com/mmcdole/gofeed"
 	"github.com/justanotherspy/rssy/internal/database"
 	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	userAgent    = "rssy/1.0 (+https://github.com/justanotherspy/rssy)"
+	fetchTimeout = 30 * time.Second
 )
 
+// ErrFeedTooLarge is returned for feeds over the size limit
+var ErrFeedTooLarge = errors.New("feed is too large")
+
+// FetcherOptions controls how many feeds are fetched at once
+type FetcherOptions struct {
+	// Workers is the number of feeds fetched in parallel by FetchAllFeeds
+	Workers int
+	// PerHostLimit caps concurrent requests to any single host
+	PerHostLimit int
+	// MaxErrors deactivates a feed after this many consecutive failures;
+	// zero disables auto-deactivation
+	MaxErrors int
+	// MaxFeedSize bounds how much of a feed is read, in bytes; larger feeds
+	// fail with ErrFeedTooLarge. Zero means no limit.
+	MaxFeedSize int64
+	// AllowPrivate lets feeds and discovered pages be fetched from the
+	// server's own network. Any user can add a feed, so it is off unless
+	// every user is trusted.
+	AllowPrivate bool
+}
+
+// FetchResult summarises the outcome of fetching a single feed
+type FetchResult struct {
+	FeedID      int64  `json:"feed_id"`
+	FeedName    string `json:"feed_name"`
+	NewPosts    int    `json:"new_posts"`
+	NotModified bool   `json:"not_modified"`
+	Error       string `json:"error,omitempty"`
+}
+
 type FeedFetcher struct {
-	db     *database.DB
-	parser *gofeed.Parser
+	db       *database.DB
+	events   *EventHub
+	clusters *StoryClusterer
+	client   *http.Client
+	opts     FetcherOptions
+
+	hostMu    sync.Mutex
+	hostSlots map[string]chan struct{}
 }
 
-func NewFeedFetcher(db *database.DB) *FeedFetcher {
+// NewFeedFetcher creates a fetcher that announces new posts and feed error
+// changes on events, which may be nil, and groups new posts into stories
+// with clusters
+func NewFeedFetcher(db *database.DB, events *EventHub, clusters *StoryClusterer, opts FetcherOptions) *FeedFetcher {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.PerHostLimit < 1 {
+		opts.PerHostLimit = 1
+	}
+
 	return &FeedFetcher{
-		db:     db,
-		parser: gofeed.NewParser(),
+		db:        db,
+		events:    events,
+		clusters:  clusters,
+		client:    &http.Client{Timeout: fetchTimeout, Transport: newPublicTransport(opts.AllowPrivate)},
+		opts:      opts,
+		hostSlots: make(map[string]chan struct{}),
+	}
+}
+
+// FetchFeed fetches and parses a single feed. The request is made
+// conditional on the ETag and Last-Modified values stored from the previous
+// fetch; a 304 Not Modified response is treated as "no new posts".
+// The returned result is always non-nil and records any error as well.
+func (f *FeedFetcher) FetchFeed(feed *models.Feed) (*FetchResult, error) {
+	result := &FetchResult{FeedID: feed.ID, FeedName: feed.Name}
+
+	newPosts, notModified, err := f.fetchFeed(feed)
+	result.NewPosts = newPosts
+	result.NotModified = notModified
+	if err != nil {
+		result.Error = err.Error()
+		f.recordError(feed, err)
+		return result, err
+	}
+
+	if feed.ErrorCount > 0 || feed.LastError != nil {
+		if err := f.db.ClearFeedError(feed.ID); err != nil {
+			log.Printf("Error clearing feed error state: %v", err)
+		} else {
+			f.events.Publish(EventFeedRecovered, 0, feed.ID, models.FeedStatusEvent{
+				FeedID:   feed.ID,
+				IsActive: feed.IsActive,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// recordError stores a failed fetch on the feed, deactivating it once it
+// has failed MaxErrors times in a row
+func (f *FeedFetcher) recordError(feed *models.Feed, fetchErr error) {
+	updated, err := f.db.RecordFeedError(feed.ID, fetchErr.Error(), f.opts.MaxErrors)
+	if err != nil {
+		log.Printf("Error recording feed error: %v", err)
+		return
+	}
+
+	if feed.IsActive && !updated.IsActive {
+		log.Printf("Deactivated feed %s after %d consecutive errors", feed.Name, updated.ErrorCount)
 	}
+
+	f.events.Publish(EventFeedError, 0, feed.ID, models.FeedStatusEvent{
+		FeedID:     updated.ID,
+		IsActive:   updated.IsActive,
+		ErrorCount: updated.ErrorCount,
+		LastError:  updated.LastError,
+	})
 }
 
-// FetchFeed fetches and parses a single feed
-func (f *FeedFetcher) FetchFeed(feed *models.Feed) error {
+func (f *FeedFetcher) fetchFeed(feed *models.Feed) (int, bool, error) {
 	log.Printf("Fetching feed: %s (%s)", feed.Name, feed.URL)
 
-	parsedFeed, err := f.parser.ParseURL(feed.URL)
+	req, err := http.NewRequest(http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid feed URL: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if feed.ETag != nil && *feed.ETag != "" {
+		req.Header.Set("If-None-Match", *feed.ETag)
+	}
+	if feed.LastModified != nil && *feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", *feed.LastModified)
+	}
+
+	release := f.acquireHost(req.URL)
+	defer release()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		log.Printf("Error fetching feed %s: %v", feed.Name, err)
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	// Servers can ask to be polled less often; the feed body may add to this
+	hint := httpScheduleHint(resp.Header, time.Now())
+	defer func() { f.storeScheduleHint(feed, hint) }()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if err := f.db.UpdateFeedLastFetched(feed.ID, time.Now()); err != nil {
+			log.Printf("Error updating feed last fetched time: %v", err)
+		}
+		log.Printf("Feed %s not modified since last fetch", feed.Name)
+		return 0, true, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		log.Printf("Error fetching feed %s: %v", feed.Name, err)
+		return 0, false, err
+	}
+
+	// Every worker may hold a feed in memory at once, so each is bounded
+	body, err := f.readFeedBody(resp)
+	if err != nil {
+		log.Printf("Error reading feed %s: %v", feed.Name, err)
+		return 0, false, err
+	}
+
+	// gofeed.Parser lazily initialises its translators, so it is not safe to
+	// share between concurrent fetches
+	parsedFeed, err := newParser().Parse(bytes.NewReader(body))
 	if err != nil {
 		log.Printf("Error parsing feed %s: %v", feed.Name, err)
-		return err
+		return 0, false, err
 	}
+	hint = max(hint, feedScheduleHint(parsedFeed))
+
+	newPostCount := len(f.storeItems(feed, parsedFeed.Items))
 
-	// Process each item in the feed
-	newPostCount := 0
-	for _, item := range parsedFeed.Items {
+	// Update feed last fetched time
+	if err := f.db.UpdateFeedLastFetched(feed.ID, time.Now()); err != nil {
+		log.Printf("Error updating feed last fetched time: %v", err)
+	}
+
+	// Remember validators for the next conditional request
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if err := f.db.UpdateFeedCacheHeaders(feed.ID, etag, lastModified); err != nil {
+		log.Printf("Error updating feed cache headers: %v", err)
+	}
+
+	log.Printf("Fetched %d new posts from %s", newPostCount, feed.Name)
+	return newPostCount, false, nil
+}
+
+// readFeedBody reads a feed response, failing with ErrFeedTooLarge once it
+// exceeds MaxFeedSize
+func (f *FeedFetcher) readFeedBody(resp *http.Response) ([]byte, error) {
+	limit := f.opts.MaxFeedSize
+	if limit <= 0 {
+		return io.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > limit {
+		return nil, ErrFeedTooLarge
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrFeedTooLarge
+	}
+	return body, nil
+}
+
+// storeItems saves the items that are not yet stored, or pruned, as
+// sanitized posts of the feed with the best lead image the item offers,
+// applies the subscribers' filter rules to them, queues their article pages
+// to be fetched if the feed wants full text or page images,
+// announces them to the feed's subscribers and returns them. Items every
+// subscriber drops are not stored.
+func (f *FeedFetcher) storeItems(feed *models.Feed, items []*gofeed.Item) []models.Post {
+	filters := f.loadFeedFilters(feed)
+
+	created := []models.Post{}
+	for _, item := range items {
 		// Check if post already exists
 		existing, err := f.db.GetPostByGUID(feed.ID, item.GUID)
 		if err != nil {
@@ -45,6 +255,15 @@ func (f *FeedFetcher) FetchFeed(feed *models.Feed) error {
 			continue // Post already exists
 		}
 
+		pruned, err := f.db.IsPostPruned(feed.ID, item.GUID)
+		if err != nil {
+			log.Printf("Error checking post tombstone: %v", err)
+			continue
+		}
+		if pruned {
+			continue // Removed by retention; don't bring it back
+		}
+
 		// Create new post
 		post := &models.Post{
 			FeedID:      feed.ID,
@@ -54,49 +273,180 @@ func (f *FeedFetcher) FetchFeed(feed *models.Feed) error {
 			Content:     item.Content,
 			Author:      getAuthor(item),
 			PublishedAt: getPublishedTime(item),
-			ImageURL:    getImageURL(item),
 			GUID:        item.GUID,
 		}
+		SanitizePost(post, feedSiteURL(feed))
+		post.ImageURL, post.ImageSource = FindLeadImage(ImageSource{
+			Item:    item,
+			Post:    post,
+			BaseURL: postBase(post.Link, feedSiteURL(feed)),
+		}, FeedImageStrategies)
+		f.clusters.Sign(post)
+
+		actions, dropped := filters.evaluate(post)
+		if dropped {
+			continue
+		}
 
 		if err := f.db.CreatePost(post); err != nil {
 			log.Printf("Error creating post: %v", err)
 			continue
 		}
+		if len(actions) > 0 {
+			if err := f.db.ApplyFilterActions(post.ID, actions); err != nil {
+				log.Printf("Error applying filter rules: %v", err)
+			}
+		}
+		if cluster, err := f.clusters.Assign(post.ID); err != nil {
+			log.Printf("Error clustering post: %v", err)
+		} else {
+			post.ClusterID = cluster
+		}
+		if post.Link != "" && (feed.FetchFullText || (feed.FetchPageImage && post.ImageURL == "") ||
+			f.clusters.ResolvesLinks()) {
+			if err := f.db.EnqueueExtraction(post.ID); err != nil {
+				log.Printf("Error queueing article fetch: %v", err)
+			}
+		}
+		f.enqueueWebhooks(feed, post)
 
-		newPostCount++
+		created = append(created, *post)
 	}
 
-	// Update feed last fetched time
-	if err := f.db.UpdateFeedLastFetched(feed.ID, time.Now()); err != nil {
-		log.Printf("Error updating feed last fetched time: %v", err)
+	if len(created) > 0 {
+		f.events.Publish(EventPostsCreated, 0, feed.ID, models.NewPostsEvent{
+			FeedID:   feed.ID,
+			FeedName: feed.Name,
+			Posts:    created,
+		})
 	}
 
-	log.Printf("Fetched %d new posts from %s", newPostCount, feed.Name)
-	return nil
+	return created
+}
+
+// enqueueWebhooks queues a delivery of a new post to every webhook that
+// matches it
+func (f *FeedFetcher) enqueueWebhooks(feed *models.Feed, post *models.Post) {
+	payload, err := json.Marshal(models.WebhookPayload{
+		Event: models.WebhookEventPostCreated,
+		Feed:  models.WebhookFeed{ID: feed.ID, Name: feed.Name, URL: feed.URL, Category: feed.Category},
+		Post:  *post,
+	})
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	if _, err := f.db.EnqueueWebhookDeliveries(feed.ID, post.ID, models.WebhookEventPostCreated, payload); err != nil {
+		log.Printf("Error queueing webhook deliveries: %v", err)
+	}
+}
+
+// storeScheduleHint records the earliest time the feed's server wants to be
+// polled again, or clears a previous hint when none was given
+func (f *FeedFetcher) storeScheduleHint(feed *models.Feed, hint time.Duration) {
+	var next *time.Time
+	if hint > 0 {
+		at := time.Now().Add(min(hint, maxScheduleHint)).UTC()
+		next = &at
+	} else if feed.NextFetchAt == nil {
+		return
+	}
+
+	if err := f.db.UpdateFeedNextFetch(feed.ID, next); err != nil {
+		log.Printf("Error updating feed next fetch time: %v", err)
+	}
 }
 
-// FetchAllFeeds fetches all active feeds
-func (f *FeedFetcher) FetchAllFeeds() error {
-	feeds, err := f.db.GetAllFeeds()
+// FetchAllFeeds fetches all active, subscribed feeds using a bounded pool
+// of workers and returns one result per feed, in the same order as
+// GetSubscribedFeeds
+func (f *FeedFetcher) FetchAllFeeds() ([]FetchResult, error) {
+	feeds, err := f.db.GetSubscribedFeeds()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return f.FetchFeeds(activeFeeds(feeds)), nil
+}
+
+// FetchUserFeeds fetches the active feeds a user subscribes to, like
+// FetchAllFeeds
+func (f *FeedFetcher) FetchUserFeeds(userID int64) ([]FetchResult, error) {
+	feeds, err := f.db.GetUserFeeds(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.FetchFeeds(activeFeeds(feeds)), nil
+}
+
+func activeFeeds(feeds []models.Feed) []models.Feed {
+	active := make([]models.Feed, 0, len(feeds))
 	for _, feed := range feeds {
-		if !feed.IsActive {
-			continue
+		if feed.IsActive {
+			active = append(active, feed)
 		}
+	}
+	return active
+}
 
-		if err := f.FetchFeed(&feed); err != nil {
-			log.Printf("Failed to fetch feed %s: %v", feed.Name, err)
-			// Continue with other feeds even if one fails
-		}
+// FetchFeeds fetches the given feeds in parallel. A failure in one feed does
+// not stop the others; it is recorded in that feed's result instead.
+func (f *FeedFetcher) FetchFeeds(feeds []models.Feed) []FetchResult {
+	results := make([]FetchResult, len(feeds))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < f.opts.Workers && w < len(feeds); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result, err := f.FetchFeed(&feeds[i])
+				if err != nil {
+					log.Printf("Failed to fetch feed %s: %v", feeds[i].Name, err)
+				}
+				results[i] = *result
+			}
+		}()
 	}
 
-	return nil
+	for i := range feeds {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// acquireHost blocks until a request slot for the URL's host is free and
+// returns a function that releases it
+func (f *FeedFetcher) acquireHost(u *url.URL) func() {
+	f.hostMu.Lock()
+	slots, ok := f.hostSlots[u.Host]
+	if !ok {
+		slots = make(chan struct{}, f.opts.PerHostLimit)
+		f.hostSlots[u.Host] = slots
+	}
+	f.hostMu.Unlock()
+
+	slots <- struct{}{}
+	return func() { <-slots }
 }
 
 // Helper functions
+
+// feedSiteURL is what relative links in a post are resolved against when
+// the post's own link is relative: the feed's website, or else the feed
+func feedSiteURL(feed *models.Feed) string {
+	if feed.SiteURL != nil && *feed.SiteURL != "" {
+		return *feed.SiteURL
+	}
+	return feed.URL
+}
+
 func getAuthor(item *gofeed.Item) string {
 	if item.Author != nil {
 		return item.Author.Name
@@ -113,18 +463,3 @@ func getPublishedTime(item *gofeed.Item) *time.Time {
 	}
 	return nil
 }
-
-func getImageURL(item *gofeed.Item) string {
-	if item.Image != nil {
-		return item.Image.URL
-	}
-
-	// Try to find image in enclosures
-	for _, enclosure := range item.Enclosures {
-		if enclosure.Type != "" && len(enclosure.Type) >= 5 && enclosure.Type[:5] == "image" {
-			return enclosure.URL
-		}
-	}
-
-	return ""
-}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// newTestDB opens a migrated database in a temporary directory with one
+// user
+func newTestDB(t *testing.T) (*database.DB, *models.User) {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "rssy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.CreateUser("test", "not-a-real-hash", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, user
+}
+
+// newTestFeed adds a feed at url and subscribes the user to it
+func newTestFeed(t *testing.T, db *database.DB, user *models.User, url string) *models.Feed {
+	t.Helper()
+
+	feed, err := db.CreateFeed(models.CreateFeedRequest{Name: "Test Feed", URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Subscribe(user.ID, feed.ID); err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+func newTestFetcher(db *database.DB, opts FetcherOptions) *FeedFetcher {
+	opts.AllowPrivate = true
+	return NewFeedFetcher(db, nil, NewStoryClusterer(db, ClusterOptions{}), opts)
+}
+
+const testRSS = `<?xml version="1.0"?>
+<rss version="2.0"><channel>
+<title>Test</title><link>https://example.com/</link>
+<item><title>First post</title><link>https://example.com/1</link><guid>1</guid></item>
+<item><title>Second post</title><link>https://example.com/2</link><guid>2</guid></item>
+</channel></rss>`
+
+func TestFetchFeedConditionalGet(t *testing.T) {
+	const etag = `"v1"`
+	const lastModified = "Wed, 01 May 2024 10:00:00 GMT"
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if n > 1 {
+			t.Errorf("request %d: If-None-Match %q, If-Modified-Since %q", n,
+				r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since"))
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprint(w, testRSS)
+	}))
+	defer server.Close()
+
+	db, user := newTestDB(t)
+	feed := newTestFeed(t, db, user, server.URL)
+	fetcher := newTestFetcher(db, FetcherOptions{})
+
+	result, err := fetcher.FetchFeed(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NewPosts != 2 || result.NotModified {
+		t.Errorf("first fetch = %+v, want 2 new posts", result)
+	}
+
+	feed, err = db.GetFeedByID(feed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.ETag == nil || *feed.ETag != etag || feed.LastModified == nil || *feed.LastModified != lastModified {
+		t.Fatalf("stored validators = %v, %v", feed.ETag, feed.LastModified)
+	}
+
+	result, err = fetcher.FetchFeed(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NewPosts != 0 || !result.NotModified {
+		t.Errorf("second fetch = %+v, want not modified", result)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("server saw %d requests, want 2", n)
+	}
+
+	fetched, err := db.GetFeedByID(feed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.ETag == nil || *fetched.ETag != etag || fetched.ErrorCount != 0 {
+		t.Errorf("after a 304: validators %v, error count %d", fetched.ETag, fetched.ErrorCount)
+	}
+}
+
+// inFlight counts concurrent requests, remembering the peak
+type inFlight struct {
+	mu      sync.Mutex
+	current int
+	peak    int
+}
+
+func (c *inFlight) add(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current += n
+	c.peak = max(c.peak, c.current)
+}
+
+func (c *inFlight) max() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peak
+}
+
+// newSlowFeedServer serves testRSS slowly, counting requests in flight in
+// each of counters
+func newSlowFeedServer(t *testing.T, counters ...*inFlight) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range counters {
+			c.add(1)
+		}
+		time.Sleep(20 * time.Millisecond)
+		for _, c := range counters {
+			c.add(-1)
+		}
+		fmt.Fprint(w, testRSS)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchFeedsBounds(t *testing.T) {
+	db, user := newTestDB(t)
+
+	var total, perHost inFlight
+	busy := newSlowFeedServer(t, &total, &perHost)
+	var feeds []models.Feed
+	for i := range 8 {
+		feeds = append(feeds, *newTestFeed(t, db, user, fmt.Sprintf("%s/%d.xml", busy.URL, i)))
+	}
+	for range 4 {
+		quiet := newSlowFeedServer(t, &total)
+		feeds = append(feeds, *newTestFeed(t, db, user, quiet.URL+"/feed.xml"))
+	}
+
+	fetcher := newTestFetcher(db, FetcherOptions{Workers: 4, PerHostLimit: 2})
+	results := fetcher.FetchFeeds(feeds)
+
+	for i, result := range results {
+		if result.FeedID != feeds[i].ID || result.NewPosts != 2 || result.Error != "" {
+			t.Errorf("result %d = %+v", i, result)
+		}
+	}
+	if peak := perHost.max(); peak != 2 {
+		t.Errorf("one host had %d requests in flight at once, want the per-host limit of 2", peak)
+	}
+	if peak := total.max(); peak > 4 {
+		t.Errorf("%d requests in flight at once, want at most the 4 workers", peak)
+	}
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// maxFilterPatternLength bounds the patterns users can store
+const maxFilterPatternLength = 1000
+
+// FilterMatcher is a compiled filter rule
+type FilterMatcher struct {
+	Rule models.FilterRule
+
+	substring string
+	re        *regexp.Regexp
+}
+
+// CompileFilterRule validates a rule and prepares it for matching
+func CompileFilterRule(rule models.FilterRule) (*FilterMatcher, error) {
+	switch rule.Field {
+	case models.FilterFieldTitle, models.FilterFieldContent, models.FilterFieldAuthor, models.FilterFieldLink:
+	default:
+		return nil, fmt.Errorf("field must be one of title, content, author or link")
+	}
+
+	switch rule.Action {
+	case models.FilterActionMarkRead, models.FilterActionStar, models.FilterActionDrop:
+	case models.FilterActionTag:
+		if rule.Tag == "" {
+			return nil, fmt.Errorf("tag is required for the tag action")
+		}
+	default:
+		return nil, fmt.Errorf("action must be one of mark_read, star, drop or tag")
+	}
+
+	if rule.Pattern == "" {
+		return nil, fmt.Errorf("pattern is required")
+	}
+	if len(rule.Pattern) > maxFilterPatternLength {
+		return nil, fmt.Errorf("pattern must be at most %d characters", maxFilterPatternLength)
+	}
+
+	m := &FilterMatcher{Rule: rule}
+	switch rule.MatchType {
+	case models.FilterMatchSubstring:
+		m.substring = strings.ToLower(rule.Pattern)
+	case models.FilterMatchRegex:
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("match_type must be substring or regex")
+	}
+
+	return m, nil
+}
+
+// Match reports whether the post matches the rule's pattern. Feed and
+// category scope is not checked.
+func (m *FilterMatcher) Match(post *models.Post) bool {
+	var values []string
+	switch m.Rule.Field {
+	case models.FilterFieldTitle:
+		values = []string{post.Title}
+	case models.FilterFieldContent:
+		// match the text readers see, not tag names and attributes
+		values = []string{PlainText(post.Description), PlainText(post.Content)}
+	case models.FilterFieldAuthor:
+		values = []string{post.Author}
+	case models.FilterFieldLink:
+		values = []string{post.Link}
+	}
+
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if m.re != nil {
+			if m.re.MatchString(value) {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(value), m.substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// feedFilters are the filter rules of every subscriber of one feed
+type feedFilters struct {
+	subscribers []int64
+	matchers    []*FilterMatcher
+}
+
+// loadFeedFilters loads the rules that apply to new posts of the feed. It
+// returns nil when there are none.
+func (f *FeedFetcher) loadFeedFilters(feed *models.Feed) *feedFilters {
+	rules, err := f.db.GetFeedFilterRules(feed)
+	if err != nil {
+		log.Printf("Error loading filter rules for %s: %v", feed.Name, err)
+		return nil
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	subscribers, err := f.db.GetFeedSubscribers(feed.ID)
+	if err != nil {
+		log.Printf("Error loading subscribers of %s: %v", feed.Name, err)
+		return nil
+	}
+
+	filters := &feedFilters{subscribers: subscribers}
+	for _, rule := range rules {
+		m, err := CompileFilterRule(rule)
+		if err != nil {
+			log.Printf("Skipping filter rule %d: %v", rule.ID, err)
+			continue
+		}
+		filters.matchers = append(filters.matchers, m)
+	}
+
+	return filters
+}
+
+// evaluate returns what each user's rules do to a post, and whether every
+// subscriber dropped it so it need not be stored at all. A drop overrides
+// the other actions of the same user.
+func (ff *feedFilters) evaluate(post *models.Post) (map[int64]models.FilterActions, bool) {
+	if ff == nil {
+		return nil, false
+	}
+
+	actions := make(map[int64]models.FilterActions)
+	for _, m := range ff.matchers {
+		if !m.Match(post) {
+			continue
+		}
+
+		a := actions[m.Rule.UserID]
+		switch m.Rule.Action {
+		case models.FilterActionMarkRead:
+			a.MarkRead = true
+		case models.FilterActionStar:
+			a.Star = true
+		case models.FilterActionDrop:
+			a.Drop = true
+		case models.FilterActionTag:
+			if !slices.Contains(a.Tags, m.Rule.Tag) {
+				a.Tags = append(a.Tags, m.Rule.Tag)
+			}
+		}
+		actions[m.Rule.UserID] = a
+	}
+
+	for userID, a := range actions {
+		if a.Drop {
+			actions[userID] = models.FilterActions{Drop: true}
+		}
+	}
+
+	dropAll := len(ff.subscribers) > 0
+	for _, userID := range ff.subscribers {
+		if !actions[userID].Drop {
+			dropAll = false
+			break
+		}
+	}
+
+	return actions, dropAll
+}
+
+// Dry run bounds. Matching happens in Go, so the scan stops after
+// dryRunMaxScanned posts even if fewer than the requested matches were found.
+const (
+	dryRunPageSize   = 500
+	dryRunMaxScanned = 5000
+)
+
+// DryRunFilterRule lists the user's existing posts in the rule's scope that
+// it would match, newest first, up to limit matches. Nothing is changed.
+func DryRunFilterRule(db *database.DB, m *FilterMatcher, limit int) (*models.FilterDryRun, error) {
+	filter := models.PostFilter{UserID: m.Rule.UserID, Limit: dryRunPageSize}
+	if m.Rule.FeedID != nil {
+		filter.FeedIDs = []int64{*m.Rule.FeedID}
+	}
+	if m.Rule.Category != nil {
+		filter.Category = *m.Rule.Category
+	}
+
+	result := &models.FilterDryRun{Posts: []models.PostWithFeed{}}
+	for {
+		page, err := db.ListPosts(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, post := range page.Posts {
+			if result.Scanned == dryRunMaxScanned {
+				result.Truncated = true
+				return result, nil
+			}
+			result.Scanned++
+
+			if m.Match(&post.Post) {
+				result.Posts = append(result.Posts, post)
+				if len(result.Posts) == limit {
+					result.Truncated = i < len(page.Posts)-1 || page.HasMore
+					return result, nil
+				}
+			}
+		}
+
+		if !page.HasMore {
+			return result, nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
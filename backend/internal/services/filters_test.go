@@ -0,0 +1,118 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func mustCompile(t *testing.T, rule models.FilterRule) *FilterMatcher {
+	t.Helper()
+	m, err := CompileFilterRule(rule)
+	if err != nil {
+		t.Fatalf("CompileFilterRule(%+v): %v", rule, err)
+	}
+	return m
+}
+
+func TestCompileFilterRuleRejects(t *testing.T) {
+	valid := models.FilterRule{Field: models.FilterFieldTitle, MatchType: models.FilterMatchSubstring, Pattern: "go", Action: models.FilterActionStar}
+
+	tests := []struct {
+		name   string
+		change func(r *models.FilterRule)
+	}{
+		{"unknown field", func(r *models.FilterRule) { r.Field = "guid" }},
+		{"unknown action", func(r *models.FilterRule) { r.Action = "delete" }},
+		{"tag without a tag", func(r *models.FilterRule) { r.Action = models.FilterActionTag }},
+		{"empty pattern", func(r *models.FilterRule) { r.Pattern = "" }},
+		{"unknown match type", func(r *models.FilterRule) { r.MatchType = "glob" }},
+		{"bad regex", func(r *models.FilterRule) { r.MatchType = models.FilterMatchRegex; r.Pattern = "(" }},
+	}
+	for _, tt := range tests {
+		rule := valid
+		tt.change(&rule)
+		if _, err := CompileFilterRule(rule); err == nil {
+			t.Errorf("%s: CompileFilterRule accepted %+v", tt.name, rule)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	post := &models.Post{
+		Title:       "Go 1.25 Released",
+		Link:        "https://go.dev/blog/go1.25",
+		Author:      "The Go Team",
+		Description: `<p>Read the <a href="https://go.dev/sponsored">notes</a></p>`,
+		Content:     `<div class="promo"><p>Faster builds &amp; smaller binaries</p></div>`,
+	}
+
+	tests := []struct {
+		field, matchType, pattern string
+		want                      bool
+	}{
+		{models.FilterFieldTitle, models.FilterMatchSubstring, "released", true},
+		{models.FilterFieldTitle, models.FilterMatchSubstring, "rust", false},
+		{models.FilterFieldTitle, models.FilterMatchRegex, `^Go 1\.\d+ `, true},
+		{models.FilterFieldTitle, models.FilterMatchRegex, `^go`, false},
+		{models.FilterFieldAuthor, models.FilterMatchSubstring, "go team", true},
+		{models.FilterFieldLink, models.FilterMatchRegex, `go\.dev/blog/`, true},
+		{models.FilterFieldContent, models.FilterMatchSubstring, "notes", true},
+		{models.FilterFieldContent, models.FilterMatchSubstring, "builds & smaller", true},
+		// Markup is not matched, only the text
+		{models.FilterFieldContent, models.FilterMatchSubstring, "sponsored", false},
+		{models.FilterFieldContent, models.FilterMatchSubstring, "promo", false},
+		{models.FilterFieldContent, models.FilterMatchRegex, `<p>`, false},
+	}
+	for _, tt := range tests {
+		m := mustCompile(t, models.FilterRule{Field: tt.field, MatchType: tt.matchType, Pattern: tt.pattern, Action: models.FilterActionStar})
+		if got := m.Match(post); got != tt.want {
+			t.Errorf("%s %s %q: Match = %v, want %v", tt.field, tt.matchType, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestFeedFiltersEvaluate(t *testing.T) {
+	rule := func(userID int64, pattern, action, tag string) *FilterMatcher {
+		return mustCompile(t, models.FilterRule{
+			UserID: userID, Field: models.FilterFieldTitle, MatchType: models.FilterMatchSubstring,
+			Pattern: pattern, Action: action, Tag: tag,
+		})
+	}
+	ff := &feedFilters{
+		subscribers: []int64{1, 2},
+		matchers: []*FilterMatcher{
+			rule(1, "go", models.FilterActionMarkRead, ""),
+			rule(1, "go", models.FilterActionTag, "golang"),
+			rule(1, "release", models.FilterActionTag, "golang"),
+			rule(1, "rust", models.FilterActionStar, ""),
+			rule(2, "go", models.FilterActionStar, ""),
+			rule(2, "release", models.FilterActionDrop, ""),
+		},
+	}
+
+	actions, dropAll := ff.evaluate(&models.Post{Title: "Go release notes"})
+	want := map[int64]models.FilterActions{
+		1: {MarkRead: true, Tags: []string{"golang"}},
+		2: {Drop: true},
+	}
+	if !reflect.DeepEqual(actions, want) || dropAll {
+		t.Errorf("evaluate = %+v, %v; want %+v, false", actions, dropAll, want)
+	}
+
+	ff.matchers = append(ff.matchers, rule(1, "notes", models.FilterActionDrop, ""))
+	if _, dropAll := ff.evaluate(&models.Post{Title: "Go release notes"}); !dropAll {
+		t.Error("post dropped by every subscriber was not dropped")
+	}
+
+	actions, dropAll = ff.evaluate(&models.Post{Title: "Weekly digest"})
+	if len(actions) != 0 || dropAll {
+		t.Errorf("evaluate of unmatched post = %+v, %v", actions, dropAll)
+	}
+
+	var none *feedFilters
+	if actions, dropAll := none.evaluate(&models.Post{Title: "Go"}); actions != nil || dropAll {
+		t.Errorf("evaluate without rules = %+v, %v", actions, dropAll)
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// maxScheduleHint caps how far into the future a feed's own hints can push
+// its next fetch, so a misconfigured server cannot stall a feed indefinitely
+const maxScheduleHint = 24 * time.Hour
+
+// rssTranslator extends gofeed's default RSS translation by carrying the
+// channel's <ttl> element, which the universal Feed type drops
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	if rssFeed, ok := feed.(*rss.Feed); ok && rssFeed.TTL != "" {
+		if result.Custom == nil {
+			result.Custom = map[string]string{}
+		}
+		result.Custom["ttl"] = rssFeed.TTL
+	}
+
+	return result, nil
+}
+
+// newParser returns a gofeed parser that preserves scheduling hints
+func newParser() *gofeed.Parser {
+	parser := gofeed.NewParser()
+	parser.RSSTranslator = &rssTranslator{}
+	return parser
+}
+
+// feedScheduleHint returns the minimum polling delay advertised inside the
+// feed document itself via RSS <ttl> or the syndication module
+func feedScheduleHint(feed *gofeed.Feed) time.Duration {
+	var hint time.Duration
+
+	if ttl, err := strconv.Atoi(strings.TrimSpace(feed.Custom["ttl"])); err == nil && ttl > 0 {
+		hint = time.Duration(ttl) * time.Minute
+	}
+
+	if sy, ok := feed.Extensions["sy"]; ok {
+		period := syndicationPeriod(extensionValue(sy, "updatePeriod"))
+		frequency, err := strconv.Atoi(extensionValue(sy, "updateFrequency"))
+		if err != nil || frequency < 1 {
+			frequency = 1
+		}
+		if period > 0 {
+			hint = max(hint, period/time.Duration(frequency))
+		}
+	}
+
+	return hint
+}
+
+// httpScheduleHint returns the minimum polling delay requested by the
+// response's Cache-Control max-age or Retry-After headers
+func httpScheduleHint(header http.Header, now time.Time) time.Duration {
+	var hint time.Duration
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds > 0 {
+			hint = max(hint, time.Duration(seconds)*time.Second)
+		}
+	}
+
+	if retryAfter := strings.TrimSpace(header.Get("Retry-After")); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+			hint = max(hint, time.Duration(seconds)*time.Second)
+		} else if at, err := http.ParseTime(retryAfter); err == nil && at.After(now) {
+			hint = max(hint, at.Sub(now))
+		}
+	}
+
+	return hint
+}
+
+func syndicationPeriod(period string) time.Duration {
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case "hourly":
+		return time.Hour
+	case "daily":
+		return 24 * time.Hour
+	case "weekly":
+		return 7 * 24 * time.Hour
+	case "monthly":
+		return 30 * 24 * time.Hour
+	case "yearly":
+		return 365 * 24 * time.Hour
+	}
+	return 0
+}
+
+func extensionValue(extensions map[string][]ext.Extension, name string) string {
+	if values := extensions[name]; len(values) > 0 {
+		return strings.TrimSpace(values[0].Value)
+	}
+	return ""
+}
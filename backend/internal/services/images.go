@@ -0,0 +1,270 @@
+package services
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/justanotherspy/rssy/internal/models"
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// minLeadImageSize is the least width and height, in pixels, of a lead
+// image whose size is declared; anything smaller is an icon or a pixel
+const minLeadImageSize = 80
+
+// iconURL matches image URLs that are favicons, avatars, emoji or spacers
+// rather than pictures of the story
+var iconURL = regexp.MustCompile(`(?i)favicon|apple-touch-icon|\.ico$|\.svg$|/emoji/|/smilies/|` +
+	`gravatar\.com/avatar|/avatars?/|spacer\.gif|blank\.gif|pixel\.(gif|png)`)
+
+// imageExtensions are the file extensions taken to be images when a media
+// element declares neither a medium nor a type
+var imageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+}
+
+// ImageCandidate is an image a strategy found, with its size when the
+// source declares one
+type ImageCandidate struct {
+	URL    string
+	Width  int
+	Height int
+}
+
+// ImageSource is what lead image strategies look in. Feed strategies use
+// the item and the sanitized post; page strategies use the post's article
+// page. Relative URLs are resolved against BaseURL.
+type ImageSource struct {
+	Item    *gofeed.Item
+	Post    *models.Post
+	Page    *goquery.Document
+	BaseURL *url.URL
+}
+
+// ImageStrategy finds lead image candidates, best first, in one part of a
+// post. Name is recorded as the post's image source when its image is used.
+type ImageStrategy struct {
+	Name string
+	Find func(src ImageSource) []ImageCandidate
+}
+
+// FeedImageStrategies are tried in order on every new post
+var FeedImageStrategies = []ImageStrategy{
+	{Name: models.ImageSourceMediaContent, Find: mediaContentImages},
+	{Name: models.ImageSourceMediaThumbnail, Find: mediaThumbnailImages},
+	{Name: models.ImageSourceEnclosure, Find: enclosureImages},
+	{Name: models.ImageSourceITunes, Find: itunesImages},
+	{Name: models.ImageSourceItem, Find: itemImages},
+	{Name: models.ImageSourceContent, Find: contentImages},
+}
+
+// PageImageStrategies are tried in order on the article page of a post
+// that has no lead image, when its page is fetched
+var PageImageStrategies = []ImageStrategy{
+	{Name: models.ImageSourceOpenGraph, Find: metaImages("og:image:secure_url", "og:image:url", "og:image")},
+	{Name: models.ImageSourceTwitter, Find: metaImages("twitter:image", "twitter:image:src")},
+}
+
+// FindLeadImage returns the first usable image found by the strategies,
+// tried in order, and the name of the strategy that found it. Tracking
+// pixels, icons and images declared smaller than minLeadImageSize are
+// skipped.
+func FindLeadImage(src ImageSource, strategies []ImageStrategy) (string, string) {
+	for _, strategy := range strategies {
+		for _, candidate := range strategy.Find(src) {
+			if image, ok := leadImageURL(candidate, src.BaseURL); ok {
+				return image, strategy.Name
+			}
+		}
+	}
+	return "", ""
+}
+
+// leadImageURL resolves a candidate and reports whether it can be a lead
+// image
+func leadImageURL(c ImageCandidate, base *url.URL) (string, bool) {
+	if (c.Width > 0 && c.Width < minLeadImageSize) || (c.Height > 0 && c.Height < minLeadImageSize) {
+		return "", false
+	}
+
+	u, err := url.Parse(strings.TrimSpace(c.URL))
+	if err != nil || c.URL == "" {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	if isTrackerURL(u) || iconURL.MatchString(u.Path) || iconURL.MatchString(u.Host+u.Path) {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// mediaContentImages finds Media RSS media:content images, directly in the
+// item or in a media:group, largest first
+func mediaContentImages(src ImageSource) []ImageCandidate {
+	var candidates []ImageCandidate
+	for _, content := range mediaElements(src.Item, "content") {
+		medium, contentType := content.Attrs["medium"], content.Attrs["type"]
+		isImage := medium == "image" || strings.HasPrefix(contentType, "image/") ||
+			(medium == "" && contentType == "" && imageExtensions[strings.ToLower(path.Ext(urlPath(content.Attrs["url"])))])
+		if isImage {
+			candidates = append(candidates, mediaCandidate(content))
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Width > candidates[j].Width })
+	return candidates
+}
+
+// mediaThumbnailImages finds media:thumbnail images, in the item, in a
+// media:group or attached to a media:content, largest first
+func mediaThumbnailImages(src ImageSource) []ImageCandidate {
+	var candidates []ImageCandidate
+	for _, thumbnail := range mediaElements(src.Item, "thumbnail") {
+		candidates = append(candidates, mediaCandidate(thumbnail))
+	}
+	for _, content := range mediaElements(src.Item, "content") {
+		for _, thumbnail := range content.Children["thumbnail"] {
+			candidates = append(candidates, mediaCandidate(thumbnail))
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Width > candidates[j].Width })
+	return candidates
+}
+
+// mediaElements returns the item's Media RSS elements of the given name,
+// including those inside media:group elements
+func mediaElements(item *gofeed.Item, name string) []ext.Extension {
+	if item == nil {
+		return nil
+	}
+	media := item.Extensions["media"]
+	if media == nil {
+		return nil
+	}
+
+	elements := append([]ext.Extension{}, media[name]...)
+	for _, group := range media["group"] {
+		elements = append(elements, group.Children[name]...)
+	}
+	return elements
+}
+
+func mediaCandidate(e ext.Extension) ImageCandidate {
+	width, _ := strconv.Atoi(e.Attrs["width"])
+	height, _ := strconv.Atoi(e.Attrs["height"])
+	return ImageCandidate{URL: e.Attrs["url"], Width: width, Height: height}
+}
+
+// enclosureImages finds image enclosures
+func enclosureImages(src ImageSource) []ImageCandidate {
+	if src.Item == nil {
+		return nil
+	}
+	var candidates []ImageCandidate
+	for _, enclosure := range src.Item.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "image/") {
+			candidates = append(candidates, ImageCandidate{URL: enclosure.URL})
+		}
+	}
+	return candidates
+}
+
+// itunesImages finds a podcast episode's artwork
+func itunesImages(src ImageSource) []ImageCandidate {
+	if src.Item == nil || src.Item.ITunesExt == nil || src.Item.ITunesExt.Image == "" {
+		return nil
+	}
+	return []ImageCandidate{{URL: src.Item.ITunesExt.Image}}
+}
+
+// itemImages finds the image the feed parser picked for the item, which is
+// how JSON Feed's image and banner_image arrive. Images the parser took
+// from the item's HTML are left to contentImages, which checks their size.
+func itemImages(src ImageSource) []ImageCandidate {
+	if src.Item == nil || src.Item.Image == nil || src.Item.Image.URL == "" {
+		return nil
+	}
+	image := src.Item.Image.URL
+	if strings.Contains(src.Item.Content, image) || strings.Contains(src.Item.Description, image) {
+		return nil
+	}
+	return []ImageCandidate{{URL: image}}
+}
+
+// contentImages finds the images in the post's content, then in its
+// description. The post is sanitized first, so tracking pixels are gone
+// and URLs are absolute.
+func contentImages(src ImageSource) []ImageCandidate {
+	if src.Post == nil {
+		return nil
+	}
+	var candidates []ImageCandidate
+	for _, content := range []string{src.Post.Content, src.Post.Description} {
+		if !strings.Contains(content, "<img") {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+		if err != nil {
+			continue
+		}
+		doc.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+			width, _ := strconv.Atoi(img.AttrOr("width", ""))
+			height, _ := strconv.Atoi(img.AttrOr("height", ""))
+			candidates = append(candidates, ImageCandidate{URL: img.AttrOr("src", ""), Width: width, Height: height})
+		})
+	}
+	return candidates
+}
+
+// metaImages returns a strategy that reads the page's <meta> tags with the
+// given property or name, in order of preference. Open Graph sizes are
+// taken from og:image:width and og:image:height.
+func metaImages(names ...string) func(src ImageSource) []ImageCandidate {
+	return func(src ImageSource) []ImageCandidate {
+		if src.Page == nil {
+			return nil
+		}
+
+		values := map[string]string{}
+		src.Page.Find("meta[content]").Each(func(_ int, meta *goquery.Selection) {
+			key := strings.ToLower(meta.AttrOr("property", meta.AttrOr("name", "")))
+			if _, seen := values[key]; key != "" && !seen {
+				values[key] = strings.TrimSpace(meta.AttrOr("content", ""))
+			}
+		})
+
+		width, _ := strconv.Atoi(values["og:image:width"])
+		height, _ := strconv.Atoi(values["og:image:height"])
+		var candidates []ImageCandidate
+		for _, name := range names {
+			if value := values[name]; value != "" {
+				c := ImageCandidate{URL: value}
+				if strings.HasPrefix(name, "og:") {
+					c.Width, c.Height = width, height
+				}
+				candidates = append(candidates, c)
+			}
+		}
+		return candidates
+	}
+}
+
+// urlPath returns the path of a URL, or the URL itself if it does not parse
+func urlPath(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Path
+	}
+	return raw
+}
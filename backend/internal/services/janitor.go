@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/database"
+)
+
+// JanitorOptions sets the default retention limits. A feed's own
+// retention_max_age and retention_max_posts override them.
+type JanitorOptions struct {
+	// Interval is the time between pruning passes
+	Interval time.Duration
+	// MaxAge prunes read posts fetched longer ago than this; 0 disables it
+	MaxAge time.Duration
+	// MaxPosts keeps at most this many posts per feed; 0 disables it
+	MaxPosts int
+	// DeliveryMaxAge removes finished webhook deliveries older than this
+	// from the delivery log; 0 keeps them
+	DeliveryMaxAge time.Duration
+}
+
+// Janitor periodically prunes read, unstarred posts that fall outside the
+// retention limits, and removes expired auth tokens and old webhook
+// deliveries. Unread and starred posts are never pruned.
+type Janitor struct {
+	db     *database.DB
+	opts   JanitorOptions
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewJanitor(db *database.DB, opts JanitorOptions) *Janitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Janitor{
+		db:     db,
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start begins pruning immediately and then on every interval. A
+// non-positive interval disables the janitor.
+func (j *Janitor) Start() {
+	if j.opts.Interval <= 0 {
+		log.Println("Post janitor disabled")
+		return
+	}
+
+	log.Printf("Starting post janitor with interval: %v (max age %v, max posts %d)",
+		j.opts.Interval, j.opts.MaxAge, j.opts.MaxPosts)
+
+	go func() {
+		ticker := time.NewTicker(j.opts.Interval)
+		defer ticker.Stop()
+
+		j.prune()
+
+		for {
+			select {
+			case <-ticker.C:
+				j.prune()
+			case <-j.ctx.Done():
+				log.Println("Post janitor stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the pruning loop
+func (j *Janitor) Stop() {
+	log.Println("Stopping post janitor...")
+	j.cancel()
+}
+
+func (j *Janitor) prune() {
+	pruned, err := j.db.PrunePosts(j.opts.MaxAge, j.opts.MaxPosts)
+	if err != nil {
+		log.Printf("Error pruning posts: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d posts", pruned)
+	}
+
+	expired, err := j.db.DeleteExpiredTokens()
+	if err != nil {
+		log.Printf("Error deleting expired tokens: %v", err)
+		return
+	}
+	if expired > 0 {
+		log.Printf("Deleted %d expired tokens", expired)
+	}
+
+	if j.opts.DeliveryMaxAge <= 0 {
+		return
+	}
+	deliveries, err := j.db.DeleteFinishedDeliveries(time.Now().Add(-j.opts.DeliveryMaxAge))
+	if err != nil {
+		log.Printf("Error deleting webhook deliveries: %v", err)
+		return
+	}
+	if deliveries > 0 {
+		log.Printf("Deleted %d old webhook deliveries", deliveries)
+	}
+}
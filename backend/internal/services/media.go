@@ -0,0 +1,605 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// mediaProxyPath is where the API serves proxied images
+	mediaProxyPath = "/api/media/proxy"
+	// mediaKeyFile holds the generated signing key inside the cache directory
+	mediaKeyFile = "proxy.key"
+	// mediaImageDir is the cache directory's subdirectory for images
+	mediaImageDir = "images"
+	// maxThumbnailPixels is the largest image, in pixels, decoded to make a
+	// thumbnail; bigger ones are served at full size
+	maxThumbnailPixels = 50_000_000
+	// thumbnailQuality is the JPEG quality of thumbnails
+	thumbnailQuality = 82
+	// mediaTouchInterval is how stale a cached image's access time may get
+	// before a hit refreshes it
+	mediaTouchInterval = time.Hour
+)
+
+// ThumbnailWidths are the widths, in pixels, images can be downscaled to
+var ThumbnailWidths = []int{160, 320, 640, 1280}
+
+var (
+	// ErrMediaTooLarge is returned for images over the size limit
+	ErrMediaTooLarge = errors.New("image is too large")
+	// ErrUnsupportedMedia is returned for responses that are not an image
+	// type the proxy serves
+	ErrUnsupportedMedia = errors.New("not a supported image")
+)
+
+// proxiedImageTypes are the content types the proxy serves. SVG is left
+// out on purpose: served from the API's origin, its scripts would run.
+var proxiedImageTypes = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true,
+	"image/webp": true, "image/avif": true, "image/bmp": true,
+}
+
+// MediaProxyOptions configures the image proxy
+type MediaProxyOptions struct {
+	// Secret keys the signatures of proxied URLs. When empty, a key is
+	// generated and kept in CacheDir so signed URLs survive restarts.
+	Secret string
+	// CacheDir is where images and the generated key are stored
+	CacheDir string
+	// PublicURL is the API's base URL as clients see it. When empty,
+	// proxied URLs are built from each request's host.
+	PublicURL string
+	// MaxImageSize is the largest image proxied, in bytes
+	MaxImageSize int64
+	// MaxCacheSize bounds the cache, in bytes; the least recently used
+	// images are removed beyond it. Zero means no limit.
+	MaxCacheSize int64
+	// Timeout bounds each image request
+	Timeout time.Duration
+	// AllowPrivate lets proxied images be loaded from loopback and private
+	// addresses. Image URLs are signed, but still come from feed content.
+	AllowPrivate bool
+}
+
+// MediaProxy serves feed images through the API so readers never contact
+// third-party hosts. Only URLs signed by the proxy are fetched, so it
+// cannot be used as an open proxy. Images are cached on disk, together
+// with the thumbnails made from them.
+type MediaProxy struct {
+	secret []byte
+	dir    string
+	client *http.Client
+	opts   MediaProxyOptions
+
+	mu       sync.Mutex
+	inflight map[string]*mediaCall
+	size     int64
+	pruning  bool
+}
+
+// mediaCall is a download or resize other requests for the same image wait
+// on
+type mediaCall struct {
+	done chan struct{}
+	err  error
+}
+
+// CachedImage is an image read from the proxy's cache
+type CachedImage struct {
+	*io.SectionReader
+	ContentType string
+	ModTime     time.Time
+	file        *os.File
+}
+
+// Close closes the cached file
+func (c *CachedImage) Close() error {
+	return c.file.Close()
+}
+
+// NewMediaProxy creates the proxy, preparing its cache directory
+func NewMediaProxy(opts MediaProxyOptions) (*MediaProxy, error) {
+	dir := filepath.Join(opts.CacheDir, mediaImageDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create media cache: %w", err)
+	}
+
+	secret := []byte(opts.Secret)
+	if len(secret) == 0 {
+		var err error
+		if secret, err = loadMediaKey(filepath.Join(opts.CacheDir, mediaKeyFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &MediaProxy{
+		secret:   secret,
+		dir:      dir,
+		client:   &http.Client{Timeout: opts.Timeout, Transport: newPublicTransport(opts.AllowPrivate)},
+		opts:     opts,
+		inflight: make(map[string]*mediaCall),
+	}
+
+	size, err := m.scanCache()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media cache: %w", err)
+	}
+	m.size = size
+	log.Printf("Media proxy caching images in %s (%d MB used)", dir, size>>20)
+	return m, nil
+}
+
+// loadMediaKey reads the signing key from path, generating it on first use
+func loadMediaKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) > 0 {
+		return key, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read media proxy key: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	key = []byte(hex.EncodeToString(raw))
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to store media proxy key: %w", err)
+	}
+	return key, nil
+}
+
+// Sign returns the signature of an image URL
+func (m *MediaProxy) Sign(raw string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(raw))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether sig is the signature of an image URL
+func (m *MediaProxy) Verify(raw, sig string) bool {
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(raw))
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// ProxyURL returns the signed proxy URL for an image, with base as the
+// API's URL when no public URL is configured. URLs that are not absolute
+// http(s) URLs are returned unchanged.
+func (m *MediaProxy) ProxyURL(base, raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return raw
+	}
+	if m.opts.PublicURL != "" {
+		base = m.opts.PublicURL
+	}
+
+	query := url.Values{"url": {raw}, "sig": {m.Sign(raw)}}
+	return strings.TrimRight(base, "/") + mediaProxyPath + "?" + query.Encode()
+}
+
+// RewritePost points a post's image and the images in its content at the
+// proxy
+func (m *MediaProxy) RewritePost(post *models.Post, base string) {
+	post.ImageURL = m.ProxyURL(base, post.ImageURL)
+	post.Description = m.rewriteHTML(post.Description, base)
+	post.Content = m.rewriteHTML(post.Content, base)
+	if post.FullContent != nil {
+		content := m.rewriteHTML(*post.FullContent, base)
+		post.FullContent = &content
+	}
+}
+
+// rewriteHTML proxies the img sources and video posters in sanitized HTML
+func (m *MediaProxy) rewriteHTML(content, base string) string {
+	if !strings.Contains(content, "<img") && !strings.Contains(content, "poster=") {
+		return content
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(content), &html.Node{
+		Type: html.ElementNode, Data: "div", DataAtom: atom.Div,
+	})
+	if err != nil {
+		return content
+	}
+
+	var b strings.Builder
+	for _, n := range nodes {
+		m.rewriteNode(n, base)
+		if err := html.Render(&b, n); err != nil {
+			return content
+		}
+	}
+	return b.String()
+}
+
+func (m *MediaProxy) rewriteNode(n *html.Node, base string) {
+	if n.Type == html.ElementNode {
+		for i, attr := range n.Attr {
+			if (n.DataAtom == atom.Img && attr.Key == "src") || (n.DataAtom == atom.Video && attr.Key == "poster") {
+				n.Attr[i].Val = m.ProxyURL(base, attr.Val)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		m.rewriteNode(c, base)
+	}
+}
+
+// Open returns an image from the cache, downloading it first if needed.
+// A non-zero width, which must be one of ThumbnailWidths, asks for the
+// image downscaled to that width; images that are already narrower, or
+// in a format the standard library cannot decode, are served as they are.
+func (m *MediaProxy) Open(ctx context.Context, raw string, width int) (*CachedImage, error) {
+	if width != 0 && !slices.Contains(ThumbnailWidths, width) {
+		return nil, fmt.Errorf("unsupported thumbnail width %d", width)
+	}
+
+	original := m.cachePath(raw, 0)
+	if err := m.ensure(ctx, original, func() error { return m.download(raw, original) }); err != nil {
+		return nil, err
+	}
+	if width == 0 {
+		return m.openCached(original)
+	}
+
+	thumbnail := m.cachePath(raw, width)
+	err := m.ensure(ctx, thumbnail, func() error { return m.resize(original, thumbnail, width) })
+	if errors.Is(err, errNoThumbnail) {
+		return m.openCached(original)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.openCached(thumbnail)
+}
+
+// cachePath is where an image, or one of its thumbnails, is cached
+func (m *MediaProxy) cachePath(raw string, width int) string {
+	sum := sha256.Sum256([]byte(raw))
+	name := hex.EncodeToString(sum[:])
+	if width > 0 {
+		name += "_w" + strconv.Itoa(width)
+	}
+	return filepath.Join(m.dir, name[:2], name)
+}
+
+// ensure runs fill unless path is already cached, making concurrent
+// requests for the same file wait for a single fill
+func (m *MediaProxy) ensure(ctx context.Context, path string, fill func() error) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	call, ok := m.inflight[path]
+	if !ok {
+		call = &mediaCall{done: make(chan struct{})}
+		m.inflight[path] = call
+		go func() {
+			call.err = fill()
+			m.mu.Lock()
+			delete(m.inflight, path)
+			m.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	m.mu.Unlock()
+
+	// The fill carries on if this request gives up, so the image is cached
+	// for the next one
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// download fetches an image and caches it at path
+func (m *MediaProxy) download(raw, path string) error {
+	req, err := http.NewRequest(http.MethodGet, raw, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "image/avif,image/webp,image/png,image/jpeg,image/gif,image/*;q=0.8")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	if m.opts.MaxImageSize > 0 && resp.ContentLength > m.opts.MaxImageSize {
+		return ErrMediaTooLarge
+	}
+
+	body := io.Reader(resp.Body)
+	if m.opts.MaxImageSize > 0 {
+		body = io.LimitReader(resp.Body, m.opts.MaxImageSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if m.opts.MaxImageSize > 0 && int64(len(data)) > m.opts.MaxImageSize {
+		return ErrMediaTooLarge
+	}
+
+	// The declared type is not trusted; the bytes have to be an image
+	contentType := sniffImageType(data)
+	if !proxiedImageTypes[contentType] {
+		return ErrUnsupportedMedia
+	}
+	return m.store(path, contentType, data)
+}
+
+// sniffImageType detects the content type of image data
+func sniffImageType(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" &&
+		(string(data[8:12]) == "avif" || string(data[8:12]) == "avis") {
+		return "image/avif"
+	}
+	return http.DetectContentType(data)
+}
+
+// errNoThumbnail means an image is served at full size instead
+var errNoThumbnail = errors.New("no thumbnail needed")
+
+// resize caches a thumbnail of the cached original at path
+func (m *MediaProxy) resize(original, path string, width int) error {
+	img, err := m.openCached(original)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	cfg, format, err := image.DecodeConfig(img)
+	if err != nil || cfg.Width <= width || cfg.Width*cfg.Height > maxThumbnailPixels {
+		// Formats without a standard decoder, and images small or large
+		// enough that resizing is pointless or too costly
+		return errNoThumbnail
+	}
+	if _, err := img.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	src, _, err := image.Decode(img)
+	if err != nil {
+		return errNoThumbnail
+	}
+
+	var buf bytes.Buffer
+	contentType := "image/png"
+	thumb := downscale(src, width)
+	if format == "jpeg" {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return err
+	}
+	return m.store(path, contentType, buf.Bytes())
+}
+
+// downscale shrinks an image to the given width, keeping its aspect ratio,
+// by averaging the source pixels behind every destination pixel
+func downscale(src image.Image, width int) *image.RGBA {
+	b := src.Bounds()
+	height := max(1, b.Dy()*width/b.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(bl / n >> 8), A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// store writes an image to the cache. The content type is kept on the
+// file's first line.
+func (m *MediaProxy) store(path, contentType string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(contentType + "\n")
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.size += int64(len(contentType) + 1 + len(data))
+	prune := m.opts.MaxCacheSize > 0 && m.size > m.opts.MaxCacheSize && !m.pruning
+	if prune {
+		m.pruning = true
+	}
+	m.mu.Unlock()
+
+	if prune {
+		go m.prune()
+	}
+	return nil
+}
+
+// openCached opens a cached image, marking it as recently used
+func (m *MediaProxy) openCached(path string) (*CachedImage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	contentType, err := bufio.NewReader(io.LimitReader(file, 64)).ReadString('\n')
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("corrupt cache entry %s", filepath.Base(path))
+	}
+	offset := int64(len(contentType))
+
+	if now := time.Now(); now.Sub(info.ModTime()) > mediaTouchInterval {
+		os.Chtimes(path, now, now)
+	}
+
+	return &CachedImage{
+		SectionReader: io.NewSectionReader(file, offset, info.Size()-offset),
+		ContentType:   strings.TrimSpace(contentType),
+		ModTime:       info.ModTime(),
+		file:          file,
+	}, nil
+}
+
+// cachedFile is an entry found while scanning the cache
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// cachedFiles lists the cache, removing temporary files left by a crash
+// when removeTemp is set
+func (m *MediaProxy) cachedFiles(removeTemp bool) ([]cachedFile, error) {
+	var files []cachedFile
+	err := filepath.WalkDir(m.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			if removeTemp {
+				os.Remove(path)
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, cachedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// scanCache returns the size of the cache
+func (m *MediaProxy) scanCache() (int64, error) {
+	files, err := m.cachedFiles(true)
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	return size, err
+}
+
+// prune removes the least recently used images until the cache is back
+// under nine tenths of its limit
+func (m *MediaProxy) prune() {
+	defer func() {
+		m.mu.Lock()
+		m.pruning = false
+		m.mu.Unlock()
+	}()
+
+	files, err := m.cachedFiles(false)
+	if err != nil {
+		log.Printf("Error scanning media cache: %v", err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	target := m.opts.MaxCacheSize / 10 * 9
+	removed := 0
+	for _, f := range files {
+		if size <= target {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			size -= f.size
+			removed++
+		}
+	}
+
+	m.mu.Lock()
+	m.size = size
+	m.mu.Unlock()
+	log.Printf("Media cache pruned: removed %d images, %d MB left", removed, size>>20)
+}
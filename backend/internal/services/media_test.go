@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newTestMediaProxy(t *testing.T, opts MediaProxyOptions) *MediaProxy {
+	t.Helper()
+	if opts.CacheDir == "" {
+		opts.CacheDir = t.TempDir()
+	}
+	m, err := NewMediaProxy(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMediaSignVerify(t *testing.T) {
+	m := newTestMediaProxy(t, MediaProxyOptions{Secret: "secret"})
+	const raw = "https://example.com/a.png"
+	sig := m.Sign(raw)
+
+	if !m.Verify(raw, sig) {
+		t.Error("Verify rejected its own signature")
+	}
+	if m.Verify("https://example.com/b.png", sig) {
+		t.Error("Verify accepted the signature of another URL")
+	}
+	if m.Verify(raw, sig[:len(sig)-2]) || m.Verify(raw, "") || m.Verify(raw, "not base64!") {
+		t.Error("Verify accepted a malformed signature")
+	}
+
+	other := newTestMediaProxy(t, MediaProxyOptions{Secret: "other"})
+	if other.Verify(raw, sig) {
+		t.Error("Verify accepted a signature made with another secret")
+	}
+}
+
+func TestMediaGeneratedKeyPersists(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestMediaProxy(t, MediaProxyOptions{CacheDir: dir})
+	second := newTestMediaProxy(t, MediaProxyOptions{CacheDir: dir})
+
+	const raw = "https://example.com/a.png"
+	if !second.Verify(raw, first.Sign(raw)) {
+		t.Error("signatures did not survive reopening the cache directory")
+	}
+	if newTestMediaProxy(t, MediaProxyOptions{}).Verify(raw, first.Sign(raw)) {
+		t.Error("two cache directories generated the same key")
+	}
+}
+
+func TestMediaProxyURL(t *testing.T) {
+	m := newTestMediaProxy(t, MediaProxyOptions{Secret: "secret"})
+
+	const raw = "https://example.com/a b.png?x=1"
+	proxied, err := url.Parse(m.ProxyURL("http://api.local/", raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxied.Host != "api.local" || proxied.Path != mediaProxyPath {
+		t.Errorf("proxy URL = %s", proxied)
+	}
+	if got := proxied.Query().Get("url"); got != raw || !m.Verify(got, proxied.Query().Get("sig")) {
+		t.Errorf("proxy URL %s does not carry a signed %q", proxied, raw)
+	}
+
+	for _, unchanged := range []string{"", "/relative.png", "data:image/png;base64,AAAA", "javascript:alert(1)"} {
+		if got := m.ProxyURL("http://api.local", unchanged); got != unchanged {
+			t.Errorf("ProxyURL(%q) = %q, want it unchanged", unchanged, got)
+		}
+	}
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSniffImageType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", testPNG(t, 1, 1), "image/png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"avif", []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00"), "image/avif"},
+		{"avif sequence", []byte("\x00\x00\x00\x1cftypavis\x00\x00\x00\x00"), "image/avif"},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), "text/xml; charset=utf-8"},
+		{"html", []byte("<!DOCTYPE html><html></html>"), "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		got := sniffImageType(tt.data)
+		if got != tt.want {
+			t.Errorf("%s: sniffImageType = %q, want %q", tt.name, got, tt.want)
+		}
+		if wantProxied := tt.want[:6] == "image/"; proxiedImageTypes[got] != wantProxied {
+			t.Errorf("%s: proxied = %v, want %v", tt.name, proxiedImageTypes[got], wantProxied)
+		}
+	}
+}
+
+func TestMediaProxyOpen(t *testing.T) {
+	photo := testPNG(t, 800, 400)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo":
+			// The declared type is wrong; the bytes decide
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(photo)
+		case "/logo.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+		}
+	}))
+	defer server.Close()
+
+	m := newTestMediaProxy(t, MediaProxyOptions{AllowPrivate: true, MaxImageSize: 1 << 20})
+
+	img, err := m.Open(context.Background(), server.URL+"/photo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(img)
+	img.Close()
+	if img.ContentType != "image/png" || !bytes.Equal(data, photo) {
+		t.Errorf("original: type %q, %d bytes", img.ContentType, len(data))
+	}
+
+	thumb, err := m.Open(context.Background(), server.URL+"/photo", 320)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := image.Decode(thumb)
+	thumb.Close()
+	if err != nil {
+		t.Fatalf("decoding thumbnail: %v", err)
+	}
+	if size := decoded.Bounds().Size(); size.X != 320 || size.Y != 160 {
+		t.Errorf("thumbnail is %v, want 320x160", size)
+	}
+
+	if _, err := m.Open(context.Background(), server.URL+"/logo.svg", 0); !errors.Is(err, ErrUnsupportedMedia) {
+		t.Errorf("opening an SVG = %v, want %v", err, ErrUnsupportedMedia)
+	}
+	if _, err := m.Open(context.Background(), server.URL+"/photo", 100); err == nil {
+		t.Error("Open accepted an unsupported thumbnail width")
+	}
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+	"golang.org/x/net/html/charset"
+)
+
+type opmlDocument struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Head    opmlHead    `xml:"head"`
+	Body    opmlOutline `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlOutline struct {
+	Text        string        `xml:"text,attr,omitempty"`
+	Title       string        `xml:"title,attr,omitempty"`
+	Type        string        `xml:"type,attr,omitempty"`
+	XMLURL      string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL     string        `xml:"htmlUrl,attr,omitempty"`
+	Description string        `xml:"description,attr,omitempty"`
+	Category    string        `xml:"category,attr,omitempty"`
+	Outlines    []opmlOutline `xml:"outline"`
+}
+
+// OPMLImportEntry describes what happened to one outline during an import
+type OPMLImportEntry struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Category string `json:"category,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	FeedID   int64  `json:"feed_id,omitempty"`
+}
+
+// OPMLImportReport lists the outcome of every feed outline in an import
+type OPMLImportReport struct {
+	Created []OPMLImportEntry `json:"created"`
+	Skipped []OPMLImportEntry `json:"skipped"`
+	Invalid []OPMLImportEntry `json:"invalid"`
+}
+
+// ImportOPML subscribes a user to every feed in an OPML 1.0 or 2.0
+// document, creating the feeds that are not stored yet. Folder outlines
+// become the category of the new feeds nested in them. URLs the user
+// already subscribes to are skipped.
+func ImportOPML(db *database.DB, userID int64, r io.Reader) (*OPMLImportReport, error) {
+	var doc opmlDocument
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid OPML document: %w", err)
+	}
+
+	feeds, err := db.GetUserFeeds(userID)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(feeds))
+	for _, feed := range feeds {
+		existing[feed.URL] = true
+	}
+
+	report := &OPMLImportReport{
+		Created: []OPMLImportEntry{},
+		Skipped: []OPMLImportEntry{},
+		Invalid: []OPMLImportEntry{},
+	}
+
+	var walk func(outlines []opmlOutline, folder string)
+	walk = func(outlines []opmlOutline, folder string) {
+		for _, o := range outlines {
+			name := strings.TrimSpace(o.Title)
+			if name == "" {
+				name = strings.TrimSpace(o.Text)
+			}
+
+			feedURL := strings.TrimSpace(o.XMLURL)
+			if feedURL == "" {
+				if len(o.Outlines) > 0 {
+					// A folder: its name becomes the category of its children
+					walk(o.Outlines, name)
+				} else if strings.EqualFold(o.Type, "rss") {
+					report.Invalid = append(report.Invalid, OPMLImportEntry{
+						Name: name, Reason: "missing xmlUrl",
+					})
+				}
+				continue
+			}
+
+			category := folder
+			if category == "" {
+				category = outlineCategory(o.Category)
+			}
+			if name == "" {
+				name = feedURL
+			}
+			entry := OPMLImportEntry{Name: name, URL: feedURL, Category: category}
+
+			if !IsHTTPURL(feedURL) {
+				entry.Reason = "xmlUrl is not an absolute http(s) URL"
+				report.Invalid = append(report.Invalid, entry)
+				continue
+			}
+			if existing[feedURL] {
+				entry.Reason = "feed already exists"
+				report.Skipped = append(report.Skipped, entry)
+				continue
+			}
+
+			feed, err := db.GetFeedByURL(feedURL)
+			if errors.Is(err, database.ErrNotFound) {
+				feed, err = db.CreateFeed(models.CreateFeedRequest{
+					Name:        name,
+					URL:         feedURL,
+					Category:    category,
+					SiteURL:     strings.TrimSpace(o.HTMLURL),
+					Description: strings.TrimSpace(o.Description),
+				})
+			}
+			if err == nil {
+				err = db.Subscribe(userID, feed.ID)
+			}
+			if errors.Is(err, database.ErrConflict) {
+				entry.Reason = "feed already exists"
+				report.Skipped = append(report.Skipped, entry)
+				continue
+			}
+			if err != nil {
+				entry.Reason = err.Error()
+				report.Invalid = append(report.Invalid, entry)
+				continue
+			}
+
+			existing[feedURL] = true
+			entry.FeedID = feed.ID
+			report.Created = append(report.Created, entry)
+		}
+	}
+	walk(doc.Body.Outlines, "")
+
+	return report, nil
+}
+
+// ExportOPML renders feeds as an OPML 2.0 document, grouping them into one
+// folder outline per category
+func ExportOPML(feeds []models.Feed) ([]byte, error) {
+	folders := map[string]*opmlOutline{}
+	var uncategorized []opmlOutline
+
+	for _, feed := range feeds {
+		outline := opmlOutline{
+			Text:   feed.Name,
+			Title:  feed.Name,
+			Type:   "rss",
+			XMLURL: feed.URL,
+		}
+		if feed.SiteURL != nil {
+			outline.HTMLURL = *feed.SiteURL
+		}
+		if feed.Description != nil {
+			outline.Description = *feed.Description
+		}
+
+		if feed.Category == nil || *feed.Category == "" {
+			uncategorized = append(uncategorized, outline)
+			continue
+		}
+
+		folder, ok := folders[*feed.Category]
+		if !ok {
+			folder = &opmlOutline{Text: *feed.Category, Title: *feed.Category}
+			folders[*feed.Category] = folder
+		}
+		folder.Outlines = append(folder.Outlines, outline)
+	}
+
+	categories := make([]string, 0, len(folders))
+	for category := range folders {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	doc := opmlDocument{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       "rssy subscriptions",
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, category := range categories {
+		doc.Body.Outlines = append(doc.Body.Outlines, *folders[category])
+	}
+	doc.Body.Outlines = append(doc.Body.Outlines, uncategorized...)
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), out...), nil
+}
+
+// outlineCategory takes the first entry of an OPML 2.0 category attribute,
+// which is a comma-separated list of slash-delimited paths
+func outlineCategory(attr string) string {
+	first, _, _ := strings.Cut(attr, ",")
+	parts := strings.Split(strings.Trim(strings.TrimSpace(first), "/"), "/")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+// IsHTTPURL reports whether raw is an absolute http(s) URL
+func IsHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+const testOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Exported elsewhere</title></head>
+  <body>
+    <outline text="Tech">
+      <outline text="Ars" title="Ars Technica" type="rss" xmlUrl="https://arstechnica.com/feed/" htmlUrl="https://arstechnica.com/"/>
+      <outline text="LWN" type="rss" xmlUrl="https://lwn.net/headlines/rss" description="Linux news"/>
+    </outline>
+    <outline text="Go blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom" category="/Programming/Go,/Blogs"/>
+    <outline text="Loose" type="rss" xmlUrl="https://example.com/loose.xml"/>
+    <outline text="Broken" type="rss"/>
+    <outline text="Relative" type="rss" xmlUrl="/feed.xml"/>
+    <outline text="Ars again" type="rss" xmlUrl="https://arstechnica.com/feed/"/>
+  </body>
+</opml>`
+
+// feedSummary is the part of a feed that survives an OPML round trip
+type feedSummary struct {
+	name, url, category, siteURL, description string
+}
+
+func summarizeFeeds(feeds []models.Feed) []feedSummary {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	summaries := make([]feedSummary, len(feeds))
+	for i, feed := range feeds {
+		summaries[i] = feedSummary{feed.Name, feed.URL, deref(feed.Category), deref(feed.SiteURL), deref(feed.Description)}
+	}
+	slices.SortFunc(summaries, func(a, b feedSummary) int { return strings.Compare(a.url, b.url) })
+	return summaries
+}
+
+func TestImportOPML(t *testing.T) {
+	db, user := newTestDB(t)
+
+	report, err := ImportOPML(db, user.ID, strings.NewReader(testOPML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 4 || len(report.Skipped) != 1 || len(report.Invalid) != 2 {
+		t.Errorf("report = %+v, want 4 created, 1 skipped, 2 invalid", report)
+	}
+
+	feeds, err := db.GetUserFeeds(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []feedSummary{
+		{"Ars Technica", "https://arstechnica.com/feed/", "Tech", "https://arstechnica.com/", ""},
+		{"Loose", "https://example.com/loose.xml", "", "", ""},
+		{"Go blog", "https://go.dev/blog/feed.atom", "Go", "", ""},
+		{"LWN", "https://lwn.net/headlines/rss", "Tech", "", "Linux news"},
+	}
+	if got := summarizeFeeds(feeds); !slices.Equal(got, want) {
+		t.Errorf("imported feeds = %+v, want %+v", got, want)
+	}
+
+	report, err = ImportOPML(db, user.ID, strings.NewReader(testOPML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 0 || len(report.Skipped) != 5 {
+		t.Errorf("importing again: %+v, want everything skipped", report)
+	}
+
+	if _, err := ImportOPML(db, user.ID, strings.NewReader("<opml><body>")); err == nil {
+		t.Error("ImportOPML accepted a truncated document")
+	}
+}
+
+func TestOPMLRoundTrip(t *testing.T) {
+	db, user := newTestDB(t)
+	if _, err := ImportOPML(db, user.ID, strings.NewReader(testOPML)); err != nil {
+		t.Fatal(err)
+	}
+	feeds, err := db.GetUserFeeds(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := ExportOPML(feeds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, otherUser := newTestDB(t)
+	report, err := ImportOPML(other, otherUser.ID, bytes.NewReader(exported))
+	if err != nil {
+		t.Fatalf("importing the export: %v\n%s", err, exported)
+	}
+	if len(report.Skipped) != 0 || len(report.Invalid) != 0 {
+		t.Errorf("importing the export: %+v", report)
+	}
+
+	imported, err := other.GetUserFeeds(otherUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summarizeFeeds(imported), summarizeFeeds(feeds); !slices.Equal(got, want) {
+		t.Errorf("round trip gave %+v, want %+v", got, want)
+	}
+}
@@ -5,49 +5,68 @@ import (
 	"log"
 	"time"
 
-	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
 )
 
+// resyncInterval bounds how long the poller sleeps between checks, so feeds
+// added or edited through the API are picked up promptly
+const resyncInterval = time.Minute
+
+// minPollWait stops the scheduler spinning if a due feed could not be
+// rescheduled, e.g. because its fetch result failed to persist
+const minPollWait = time.Second
+
+// PollerOptions controls the polling cadence and how failing feeds back off
+type PollerOptions struct {
+	// Interval is the default time between fetches of a feed; a feed's own
+	// refresh_interval overrides it
+	Interval time.Duration
+	// BackoffBase is how long a feed is skipped after its first failure;
+	// the delay doubles with each further consecutive failure
+	BackoffBase time.Duration
+	// BackoffMax caps the backoff delay
+	BackoffMax time.Duration
+}
+
+// Poller schedules feed fetches. Rather than polling every feed on one
+// ticker, it keeps a priority queue of each feed's next due time and wakes
+// when the earliest one comes due. The queue is kept between wake-ups;
+// only feeds that were fetched or changed since are reloaded.
 type Poller struct {
-	fetcher  *FeedFetcher
-	interval time.Duration
-	ctx      context.Context
-	cancel   context.CancelFunc
+	fetcher *FeedFetcher
+	opts    PollerOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	queue *feedQueue
+	// synced is when the queue last caught up with changed feeds; zero
+	// until every feed has been loaded
+	synced time.Time
 }
 
-func NewPoller(db *database.DB, interval time.Duration) *Poller {
+func NewPoller(fetcher *FeedFetcher, opts PollerOptions) *Poller {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Poller{
-		fetcher:  NewFeedFetcher(db),
-		interval: interval,
-		ctx:      ctx,
-		cancel:   cancel,
+		fetcher: fetcher,
+		opts:    opts,
+		ctx:     ctx,
+		cancel:  cancel,
+		queue:   newFeedQueue(),
 	}
 }
 
-// Start begins the polling loop
+// Start begins the scheduling loop. Feeds that have never been fetched are
+// due immediately.
 func (p *Poller) Start() {
-	log.Printf("Starting feed poller with interval: %v", p.interval)
+	log.Printf("Starting feed poller with default interval: %v", p.opts.Interval)
 
-	// Fetch immediately on start
-	go func() {
-		if err := p.fetcher.FetchAllFeeds(); err != nil {
-			log.Printf("Error during initial fetch: %v", err)
-		}
-	}()
-
-	// Start periodic polling
-	ticker := time.NewTicker(p.interval)
 	go func() {
 		for {
+			timer := time.NewTimer(p.runDue())
 			select {
-			case <-ticker.C:
-				log.Println("Polling feeds...")
-				if err := p.fetcher.FetchAllFeeds(); err != nil {
-					log.Printf("Error polling feeds: %v", err)
-				}
+			case <-timer.C:
 			case <-p.ctx.Done():
-				ticker.Stop()
+				timer.Stop()
 				log.Println("Feed poller stopped")
 				return
 			}
@@ -60,3 +79,135 @@ func (p *Poller) Stop() {
 	log.Println("Stopping feed poller...")
 	p.cancel()
 }
+
+// runDue fetches every active, subscribed feed whose due time has passed and returns
+// how long to wait before checking again
+func (p *Poller) runDue() time.Duration {
+	if err := p.sync(); err != nil {
+		log.Printf("Error loading feeds for polling: %v", err)
+		return resyncInterval
+	}
+
+	now := time.Now()
+	if due := p.queue.popDue(now); len(due) > 0 {
+		// Reload the due feeds, as they may have been edited or
+		// unsubscribed from since they were queued
+		feeds, err := p.reload(due)
+		if err != nil {
+			log.Printf("Error loading feeds for polling: %v", err)
+			return resyncInterval
+		}
+		if feeds = activeFeeds(feeds); len(feeds) > 0 {
+			log.Printf("Polling %d due feeds...", len(feeds))
+			logFetchSummary(p.fetcher.FetchFeeds(feeds))
+			// Fetching updates the feeds' timestamps, so reschedule them
+			// from fresh state
+			if _, err := p.reload(feeds); err != nil {
+				log.Printf("Error rescheduling feeds: %v", err)
+			}
+		}
+		return minPollWait
+	}
+
+	if p.queue.Len() == 0 {
+		return resyncInterval
+	}
+	return max(min(p.queue.peek().due.Sub(now), resyncInterval), minPollWait)
+}
+
+// sync queues the feeds changed since the last sync, or every subscribed
+// feed on the first
+func (p *Poller) sync() error {
+	started := time.Now()
+	feeds, err := p.fetcher.db.GetSubscribedFeedsChangedSince(p.synced)
+	if err != nil {
+		return err
+	}
+	for _, feed := range feeds {
+		p.schedule(feed)
+	}
+	p.synced = started
+	return nil
+}
+
+// reload queues the current state of the given feeds and returns those
+// that still have subscribers. Feeds nobody subscribes to any more are
+// dropped from the queue.
+func (p *Poller) reload(feeds []models.Feed) ([]models.Feed, error) {
+	ids := make([]int64, len(feeds))
+	for i, feed := range feeds {
+		ids[i] = feed.ID
+		p.queue.remove(feed.ID)
+	}
+
+	current, err := p.fetcher.db.GetSubscribedFeedsByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, feed := range current {
+		p.schedule(feed)
+	}
+	return current, nil
+}
+
+// schedule queues an active feed at its next due time; inactive feeds are
+// left out until they are reactivated
+func (p *Poller) schedule(feed models.Feed) {
+	if !feed.IsActive {
+		p.queue.remove(feed.ID)
+		return
+	}
+	p.queue.set(feed, p.nextDue(&feed))
+}
+
+// nextDue returns when a feed should next be fetched: its refresh interval
+// after the last attempt, pushed back by any server hint or error backoff
+func (p *Poller) nextDue(feed *models.Feed) time.Time {
+	var lastAttempt time.Time
+	if feed.LastFetchedAt != nil {
+		lastAttempt = *feed.LastFetchedAt
+	}
+	if feed.LastErrorAt != nil && feed.LastErrorAt.After(lastAttempt) {
+		lastAttempt = *feed.LastErrorAt
+	}
+	if lastAttempt.IsZero() {
+		return time.Time{}
+	}
+
+	interval := p.opts.Interval
+	if feed.RefreshInterval != nil && *feed.RefreshInterval > 0 {
+		interval = time.Duration(*feed.RefreshInterval) * time.Second
+	}
+
+	due := lastAttempt.Add(interval)
+	if feed.NextFetchAt != nil && feed.NextFetchAt.After(due) {
+		due = *feed.NextFetchAt
+	}
+	if retryAt, ok := p.backoffUntil(feed); ok && retryAt.After(due) {
+		due = retryAt
+	}
+
+	return due
+}
+
+// backoffUntil returns the earliest time a failing feed should be retried.
+// The delay starts at BackoffBase and doubles for every consecutive error.
+func (p *Poller) backoffUntil(feed *models.Feed) (time.Time, bool) {
+	if feed.ErrorCount == 0 || feed.LastErrorAt == nil || p.opts.BackoffBase <= 0 {
+		return time.Time{}, false
+	}
+
+	return feed.LastErrorAt.Add(backoffDelay(p.opts.BackoffBase, p.opts.BackoffMax, feed.ErrorCount)), true
+}
+
+// logFetchSummary logs the totals for a batch of fetches
+func logFetchSummary(results []FetchResult) {
+	newPosts, failed := 0, 0
+	for _, r := range results {
+		newPosts += r.NewPosts
+		if r.Error != "" {
+			failed++
+		}
+	}
+	log.Printf("Poll complete: %d feeds, %d new posts, %d failed", len(results), newPosts, failed)
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func TestPollerNextDue(t *testing.T) {
+	p := NewPoller(nil, PollerOptions{
+		Interval:    30 * time.Minute,
+		BackoffBase: time.Minute,
+		BackoffMax:  2 * time.Hour,
+	})
+
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	later := at.Add(5 * time.Minute)
+	tests := []struct {
+		name string
+		feed models.Feed
+		want time.Time
+	}{
+		{"never fetched", models.Feed{}, time.Time{}},
+		{"fetched", models.Feed{LastFetchedAt: &at}, at.Add(30 * time.Minute)},
+		{"own interval", models.Feed{LastFetchedAt: &at, RefreshInterval: ptr(600)}, at.Add(10 * time.Minute)},
+		{"server hint", models.Feed{LastFetchedAt: &at, NextFetchAt: ptr(at.Add(time.Hour))}, at.Add(time.Hour)},
+		// The refresh interval outlasts short backoffs
+		{"one error", models.Feed{LastFetchedAt: &at, LastErrorAt: &later, ErrorCount: 1}, later.Add(30 * time.Minute)},
+		{"six errors", models.Feed{LastFetchedAt: &at, LastErrorAt: &later, ErrorCount: 6}, later.Add(32 * time.Minute)},
+		{"eight errors", models.Feed{LastFetchedAt: &at, LastErrorAt: &later, ErrorCount: 8}, later.Add(2 * time.Hour)},
+		{"many errors", models.Feed{LastFetchedAt: &at, LastErrorAt: &later, ErrorCount: 50}, later.Add(2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		if got := p.nextDue(&tt.feed); !got.Equal(tt.want) {
+			t.Errorf("%s: nextDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	p.opts.BackoffBase = 0
+	feed := models.Feed{LastFetchedAt: &at, LastErrorAt: &later, ErrorCount: 50}
+	if got, want := p.nextDue(&feed), later.Add(30*time.Minute); !got.Equal(want) {
+		t.Errorf("without backoff: nextDue = %v, want %v", got, want)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
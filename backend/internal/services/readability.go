@@ -0,0 +1,282 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// minArticleLength is the least text, in characters, an extraction must
+// yield to count as the article rather than a cookie wall or a stub
+const minArticleLength = 250
+
+// ErrNoArticle is returned by ExtractArticle when a page has no
+// recognisable article
+var ErrNoArticle = errors.New("no article content found")
+
+// Class and id patterns used to score and prune elements, after
+// Mozilla's Readability
+var (
+	unlikelyCandidate = regexp.MustCompile(`(?i)banner|breadcrumbs|combx|comment|community|cookie|cover-wrap|disqus|extra|footer|gdpr|header|legends|menu|modal|related|remark|replies|rss|shoutbox|sidebar|skyscraper|social|sponsor|supplemental|ad-break|agegate|pagination|pager|popup|newsletter|subscribe`)
+	maybeCandidate    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveClass     = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeClass     = regexp.MustCompile(`(?i)-ad-|hidden|banner|combx|comment|com-|contact|foot|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget`)
+)
+
+// strippedElements never hold article text
+const strippedElements = "script, style, noscript, template, iframe, object, embed, form, " +
+	"nav, aside, footer, button, input, select, textarea, svg, canvas, link, meta"
+
+// scoredElements are the elements whose text scores their ancestors
+const scoredElements = "p, pre, td, blockquote, li"
+
+// blockElements are the elements that stop a div from counting as a
+// paragraph of its own
+const blockElements = "blockquote, dl, div, img, ol, p, pre, table, ul, section, article, h1, h2, h3, h4, h5, h6"
+
+// keptAttributes are the attributes left on extracted elements
+var keptAttributes = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true,
+	"width": true, "height": true, "colspan": true, "rowspan": true,
+}
+
+// ExtractArticle finds the main content of an HTML page the way
+// readability tools do: each paragraph adds to the score of its parent and
+// grandparent by its length and number of commas, class names and ids
+// nudge scores up or down, link-heavy containers are penalised, and the
+// best container is returned along with any siblings that look like part
+// of the same article. Links and images are resolved against pageURL.
+func ExtractArticle(r io.Reader, pageURL *url.URL) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return "", err
+	}
+	return extractArticle(doc, pageURL)
+}
+
+// extractArticle is ExtractArticle for a parsed page, which it modifies
+func extractArticle(doc *goquery.Document, pageURL *url.URL) (string, error) {
+	doc.Find(strippedElements).Remove()
+	doc.Find("body *").Each(func(_ int, s *goquery.Selection) {
+		if goquery.NodeName(s) == "article" || goquery.NodeName(s) == "main" {
+			return
+		}
+		match := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if unlikelyCandidate.MatchString(match) && !maybeCandidate.MatchString(match) {
+			s.Remove()
+		}
+	})
+
+	top, scores := topCandidate(doc)
+	if top == nil {
+		return "", ErrNoArticle
+	}
+
+	article := articleNodes(top, scores)
+
+	var b strings.Builder
+	b.WriteString("<div>")
+	length := 0
+	for _, s := range article {
+		cleanArticleNode(s, pageURL)
+		length += utf8.RuneCountInString(strings.TrimSpace(s.Text()))
+		if out, err := goquery.OuterHtml(s); err == nil {
+			b.WriteString(out)
+		}
+	}
+	b.WriteString("</div>")
+
+	if length < minArticleLength {
+		return "", ErrNoArticle
+	}
+	return b.String(), nil
+}
+
+// candidateScores holds the running score of every container a paragraph
+// has scored
+type candidateScores map[*html.Node]float64
+
+func (c candidateScores) add(s *goquery.Selection, score float64) {
+	if s.Length() == 0 || s.Nodes[0].Type != html.ElementNode {
+		return
+	}
+	node := s.Nodes[0]
+	if _, ok := c[node]; !ok {
+		c[node] = initialScore(s)
+	}
+	c[node] += score
+}
+
+// topCandidate returns the container with the best score once link
+// density is taken into account, or nil when no paragraph scored. The
+// scores of every container are returned too, adjusted the same way.
+func topCandidate(doc *goquery.Document) (*goquery.Selection, candidateScores) {
+	scores := candidateScores{}
+
+	paragraphs := doc.Find(scoredElements)
+	// Divs used as paragraphs, with nothing but text and inline markup
+	paragraphs = paragraphs.AddSelection(doc.Find("div").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return s.Find(blockElements).Length() == 0
+	}))
+
+	paragraphs.Each(func(_ int, p *goquery.Selection) {
+		text := strings.TrimSpace(p.Text())
+		length := utf8.RuneCountInString(text)
+		if length < 25 {
+			return
+		}
+
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(length/100), 3)
+		parent := p.Parent()
+		scores.add(parent, score)
+		scores.add(parent.Parent(), score/2)
+	})
+
+	var best *html.Node
+	for node, score := range scores {
+		score *= 1 - linkDensity(doc.FindNodes(node))
+		scores[node] = score
+		if best == nil || score > scores[best] {
+			best = node
+		}
+	}
+	if best == nil {
+		return nil, scores
+	}
+	return doc.FindNodes(best), scores
+}
+
+// articleNodes returns the top candidate together with the siblings that
+// belong to the same article: well-scored containers, and paragraphs with
+// enough text and few links
+func articleNodes(top *goquery.Selection, scores candidateScores) []*goquery.Selection {
+	topScore := scores[top.Nodes[0]]
+	threshold := math.Max(10, topScore*0.2)
+	topClass := top.AttrOr("class", "")
+
+	var nodes []*goquery.Selection
+	top.Parent().Children().Each(func(_ int, s *goquery.Selection) {
+		node := s.Nodes[0]
+		if node == top.Nodes[0] {
+			nodes = append(nodes, s)
+			return
+		}
+
+		score, scored := scores[node]
+		if topClass != "" && s.AttrOr("class", "") == topClass {
+			score += topScore * 0.2
+		}
+		if scored && score >= threshold {
+			nodes = append(nodes, s)
+			return
+		}
+
+		if goquery.NodeName(s) == "p" {
+			text := strings.TrimSpace(s.Text())
+			length := utf8.RuneCountInString(text)
+			density := linkDensity(s)
+			if (length > 80 && density < 0.25) ||
+				(length > 0 && density == 0 && strings.Contains(text, ". ")) {
+				nodes = append(nodes, s)
+			}
+		}
+	})
+	return nodes
+}
+
+// cleanArticleNode strips presentational attributes and link-heavy
+// leftovers from extracted content, loads lazy images and makes links and
+// image sources absolute
+func cleanArticleNode(s *goquery.Selection, pageURL *url.URL) {
+	s.Find("div, section, ul, ol, table").Each(func(_ int, el *goquery.Selection) {
+		if classWeight(el) < 0 || (linkDensity(el) > 0.5 && el.Find("img").Length() == 0) {
+			el.Remove()
+		}
+	})
+
+	s.Find("img").Each(func(_ int, img *goquery.Selection) {
+		if _, ok := img.Attr("src"); !ok {
+			if lazy, ok := img.Attr("data-src"); ok {
+				img.SetAttr("src", lazy)
+			}
+		}
+	})
+
+	all := s.Find("*").AddSelection(s)
+	all.Each(func(_ int, el *goquery.Selection) {
+		node := el.Nodes[0]
+		attrs := node.Attr[:0]
+		for _, attr := range node.Attr {
+			if keptAttributes[strings.ToLower(attr.Key)] {
+				attrs = append(attrs, attr)
+			}
+		}
+		node.Attr = attrs
+
+		for _, name := range []string{"href", "src"} {
+			value, ok := el.Attr(name)
+			if !ok || pageURL == nil {
+				continue
+			}
+			if abs, err := pageURL.Parse(strings.TrimSpace(value)); err == nil {
+				el.SetAttr(name, abs.String())
+			}
+		}
+	})
+}
+
+// initialScore weights a container by its tag and its class and id
+func initialScore(s *goquery.Selection) float64 {
+	score := classWeight(s)
+	switch goquery.NodeName(s) {
+	case "article", "main":
+		score += 10
+	case "div":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	return score
+}
+
+// classWeight scores an element's class and id against the positive and
+// negative patterns
+func classWeight(s *goquery.Selection) float64 {
+	weight := 0.0
+	for _, value := range []string{s.AttrOr("class", ""), s.AttrOr("id", "")} {
+		if value == "" {
+			continue
+		}
+		if negativeClass.MatchString(value) {
+			weight -= 25
+		}
+		if positiveClass.MatchString(value) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// linkDensity is the share of an element's text that sits inside links
+func linkDensity(s *goquery.Selection) float64 {
+	length := utf8.RuneCountInString(strings.TrimSpace(s.Text()))
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linkLength += utf8.RuneCountInString(strings.TrimSpace(a.Text()))
+	})
+	return float64(linkLength) / float64(length)
+}
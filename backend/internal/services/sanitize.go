@@ -0,0 +1,416 @@
+package services
+
+import (
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ExcerptLength is the most characters kept in a post's plain-text excerpt
+const ExcerptLength = 300
+
+// sanitizeBatchSize is how many stored posts are sanitized at a time
+const sanitizeBatchSize = 200
+
+// allowedTags maps every element kept by SanitizeHTML to the attributes it
+// may keep besides the global ones. Other elements are unwrapped: their
+// children are kept but the element itself is dropped.
+var allowedTags = map[string][]string{
+	"a": {"href"}, "abbr": nil, "address": nil, "article": nil, "aside": nil,
+	"audio": {"src", "controls"}, "b": nil, "bdi": nil, "bdo": nil,
+	"blockquote": {"cite"}, "br": nil, "caption": nil, "cite": nil, "code": nil,
+	"col": {"span"}, "colgroup": {"span"}, "dd": nil, "del": {"cite", "datetime"},
+	"details": {"open"}, "dfn": nil, "div": nil, "dl": nil, "dt": nil, "em": nil,
+	"figcaption": nil, "figure": nil, "footer": nil, "h1": nil, "h2": nil,
+	"h3": nil, "h4": nil, "h5": nil, "h6": nil, "header": nil, "hr": nil,
+	"i": nil, "iframe": {"src", "width", "height", "allowfullscreen"},
+	"img": {"src", "alt", "width", "height"}, "ins": {"cite", "datetime"},
+	"kbd": nil, "li": {"value"}, "mark": nil, "ol": {"start", "reversed", "type"},
+	"p": nil, "picture": nil, "pre": nil, "q": {"cite"}, "rp": nil, "rt": nil,
+	"ruby": nil, "s": nil, "samp": nil, "section": nil, "small": nil,
+	"source": {"src", "type"}, "span": nil, "strong": nil, "sub": nil,
+	"summary": nil, "sup": nil, "table": nil, "tbody": nil,
+	"td": {"colspan", "rowspan"}, "tfoot": nil, "th": {"colspan", "rowspan", "scope"},
+	"thead": nil, "time": {"datetime"}, "tr": nil, "u": nil, "ul": nil,
+	"var": nil, "video": {"src", "poster", "controls", "width", "height"}, "wbr": nil,
+}
+
+// globalAttributes may be kept on any allowed element
+var globalAttributes = []string{"title", "lang", "dir"}
+
+// urlAttributes hold URLs that must be made absolute and use a safe scheme
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true, "poster": true}
+
+// droppedTags are removed together with everything inside them
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"object": true, "embed": true, "applet": true, "frame": true, "frameset": true,
+	"form": true, "input": true, "button": true, "select": true, "textarea": true,
+	"svg": true, "math": true, "head": true, "title": true, "meta": true,
+	"link": true, "base": true,
+}
+
+// voidTags have no closing tag
+var voidTags = map[string]bool{
+	"br": true, "col": true, "hr": true, "img": true, "source": true, "wbr": true,
+}
+
+// blockTags separate words when content is flattened to text
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "li": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true,
+	"ul": true,
+}
+
+// embedHosts are the hosts iframes may load from; iframes pointing
+// anywhere else are dropped
+var embedHosts = map[string]bool{
+	"www.youtube.com": true, "youtube.com": true, "www.youtube-nocookie.com": true,
+	"player.vimeo.com": true, "www.dailymotion.com": true, "open.spotify.com": true,
+	"w.soundcloud.com": true, "bandcamp.com": true, "embed.ted.com": true,
+	"player.twitch.tv": true, "codepen.io": true,
+}
+
+// trackerHosts serve tracking pixels; images from them or their
+// subdomains are dropped
+var trackerHosts = []string{
+	"feeds.feedburner.com", "feedproxy.google.com", "pixel.wp.com",
+	"stats.wordpress.com", "google-analytics.com", "doubleclick.net",
+	"pixel.quantserve.com", "feedsportal.com", "pixel.feedly.com",
+	"analytics.twitter.com",
+}
+
+// SanitizeHTML makes feed HTML safe to render. Only allow-listed elements
+// and attributes survive; scripts, styles and forms are removed with their
+// contents, as are tracking pixels and iframes from hosts other than known
+// video and audio players. Links and media are resolved against base
+// (normally the post's link) and kept only if they are http(s), or mailto
+// for links. Links open in a new tab without a referrer.
+func SanitizeHTML(raw string, base *url.URL) string {
+	if strings.TrimSpace(raw) == "" {
+		return ""
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(raw), &html.Node{
+		Type: html.ElementNode, Data: "div", DataAtom: atom.Div,
+	})
+	if err != nil {
+		return html.EscapeString(raw)
+	}
+
+	s := &sanitizer{base: base}
+	for _, n := range nodes {
+		s.walk(n)
+	}
+	return strings.TrimSpace(s.b.String())
+}
+
+type sanitizer struct {
+	base *url.URL
+	b    strings.Builder
+}
+
+func (s *sanitizer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		s.b.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		// Comments and doctypes
+		return
+	}
+
+	tag := n.Data
+	if droppedTags[tag] {
+		return
+	}
+	if tag == "img" && s.isTrackingPixel(n) {
+		return
+	}
+
+	allowed, ok := allowedTags[tag]
+	if !ok {
+		s.walkChildren(n)
+		return
+	}
+
+	attrs, ok := s.attributes(n, allowed)
+	if !ok {
+		return
+	}
+
+	s.b.WriteString("<" + tag)
+	for _, attr := range attrs {
+		s.b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	s.b.WriteString(">")
+	if voidTags[tag] {
+		return
+	}
+	s.walkChildren(n)
+	s.b.WriteString("</" + tag + ">")
+}
+
+func (s *sanitizer) walkChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		s.walk(c)
+	}
+}
+
+// attributes returns the attributes an element keeps. It reports false
+// when the element must be dropped: an iframe from an unknown host, or
+// media without a usable source.
+func (s *sanitizer) attributes(n *html.Node, allowed []string) ([]html.Attribute, bool) {
+	var attrs []html.Attribute
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !(slices.Contains(allowed, key) || slices.Contains(globalAttributes, key)) {
+			continue
+		}
+
+		value := attr.Val
+		if urlAttributes[key] {
+			var ok bool
+			value, ok = s.resolveURL(value, n.Data == "a" && key == "href")
+			if !ok {
+				continue
+			}
+		}
+		attrs = append(attrs, html.Attribute{Key: key, Val: value})
+	}
+
+	switch n.Data {
+	case "a":
+		attrs = append(attrs,
+			html.Attribute{Key: "target", Val: "_blank"},
+			html.Attribute{Key: "rel", Val: "nofollow noopener noreferrer"},
+		)
+	case "img":
+		if attrValue(attrs, "src") == "" {
+			return nil, false
+		}
+	case "iframe":
+		src, err := url.Parse(attrValue(attrs, "src"))
+		if err != nil || src.Scheme != "https" || !embedHosts[strings.ToLower(src.Host)] {
+			return nil, false
+		}
+		attrs = append(attrs, html.Attribute{
+			Key: "sandbox", Val: "allow-scripts allow-same-origin allow-popups allow-presentation",
+		})
+	}
+	return attrs, true
+}
+
+// resolveURL makes a URL absolute against the base and reports whether it
+// uses an allowed scheme. Relative URLs are dropped when there is no base.
+func (s *sanitizer) resolveURL(raw string, allowMailto bool) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false
+	}
+	if !u.IsAbs() {
+		if s.base == nil {
+			return "", false
+		}
+		u = s.base.ResolveReference(u)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.String(), true
+	case "mailto":
+		return u.String(), allowMailto
+	default:
+		return "", false
+	}
+}
+
+// isTrackingPixel reports whether an image is a 1x1 pixel or served by a
+// known tracker
+func (s *sanitizer) isTrackingPixel(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		switch strings.ToLower(attr.Key) {
+		case "width", "height":
+			if size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(attr.Val), "px")); err == nil && size <= 1 {
+				return true
+			}
+		case "src":
+			src, ok := s.resolveURL(attr.Val, false)
+			if !ok {
+				continue
+			}
+			u, err := url.Parse(src)
+			if err != nil {
+				continue
+			}
+			if isTrackerURL(u) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTrackerURL reports whether a URL points at a known tracking host, or at
+// a FeedBurner-style pixel path
+func isTrackerURL(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, tracker := range trackerHosts {
+		if host == tracker || strings.HasSuffix(host, "."+tracker) {
+			return true
+		}
+	}
+	return strings.Contains(u.Path, "/~r/") || strings.Contains(u.Path, "/~ff/")
+}
+
+func attrValue(attrs []html.Attribute, key string) string {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// PlainText flattens HTML to text, separating blocks with spaces and
+// collapsing whitespace. Scripts and styles contribute nothing.
+func PlainText(raw string) string {
+	if strings.TrimSpace(raw) == "" {
+		return ""
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(raw), &html.Node{
+		Type: html.ElementNode, Data: "div", DataAtom: atom.Div,
+	})
+	if err != nil {
+		return strings.Join(strings.Fields(raw), " ")
+	}
+
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+		case html.ElementNode:
+			if droppedTags[n.Data] {
+				return
+			}
+			if blockTags[n.Data] {
+				b.WriteString(" ")
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+			if blockTags[n.Data] {
+				b.WriteString(" ")
+			}
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Excerpt shortens text to at most max characters, cutting at a word
+// boundary and marking the cut with an ellipsis
+func Excerpt(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	runes := []rune(text)
+	cut := string(runes[:max-1])
+	if i := strings.LastIndexByte(cut, ' '); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// SanitizePost sanitizes a new post's description and content against its
+// link, falling back to fallbackBase (the feed's site) when the link is
+// not absolute, and fills in its excerpt and searchable text
+func SanitizePost(post *models.Post, fallbackBase string) {
+	base := postBase(post.Link, fallbackBase)
+	post.Description = SanitizeHTML(post.Description, base)
+	post.Content = SanitizeHTML(post.Content, base)
+	if post.FullContent != nil {
+		full := SanitizeHTML(*post.FullContent, base)
+		post.FullContent = &full
+	}
+
+	description := PlainText(post.Description)
+	body := PlainText(post.Content)
+	if post.FullContent != nil {
+		body = PlainText(*post.FullContent)
+	}
+
+	excerpt := description
+	if excerpt == "" {
+		excerpt = body
+	}
+	post.Excerpt = Excerpt(excerpt, ExcerptLength)
+	post.TextContent = body
+	if body == "" {
+		post.TextContent = description
+	}
+}
+
+// postBase picks the URL relative links in a post are resolved against
+func postBase(link, fallback string) *url.URL {
+	var base *url.URL
+	if fb, err := url.Parse(fallback); err == nil && fb.IsAbs() {
+		base = fb
+	}
+	if u, err := url.Parse(link); err == nil {
+		if base != nil {
+			u = base.ResolveReference(u)
+		}
+		if u.IsAbs() {
+			return u
+		}
+	}
+	return base
+}
+
+// SanitizeStoredPosts sanitizes posts stored before sanitization was
+// introduced, so raw feed HTML is never served. It returns how many posts
+// it updated.
+func SanitizeStoredPosts(db *database.DB) (int, error) {
+	total := 0
+	for {
+		posts, err := db.GetUnsanitizedPosts(sanitizeBatchSize)
+		if err != nil {
+			return total, err
+		}
+
+		for i := range posts {
+			SanitizePost(&posts[i], "")
+			if err := db.UpdateSanitizedPost(&posts[i]); err != nil {
+				return total, err
+			}
+		}
+		total += len(posts)
+
+		if len(posts) < sanitizeBatchSize {
+			if total > 0 {
+				log.Printf("Sanitized %d stored posts", total)
+			}
+			return total, nil
+		}
+	}
+}
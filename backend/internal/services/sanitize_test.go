@@ -0,0 +1,110 @@
+package services
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	base, _ := url.Parse("https://example.com/posts/1")
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			"script removed with its contents",
+			`<p>Hello</p><script>alert(1)</script>`,
+			`<p>Hello</p>`,
+		},
+		{
+			"style and form removed",
+			`<style>p{}</style><form action="/x"><input name="q"></form><p>Hi</p>`,
+			`<p>Hi</p>`,
+		},
+		{
+			"event handlers stripped",
+			`<p onclick="steal()">Hi <img src="/a.jpg" onerror="steal()" alt="A"></p>`,
+			`<p>Hi <img src="https://example.com/a.jpg" alt="A"></p>`,
+		},
+		{
+			"javascript links dropped",
+			`<a href="javascript:alert(1)">x</a> <a href=" JaVaScRiPt:alert(1)">y</a>`,
+			`<a target="_blank" rel="nofollow noopener noreferrer">x</a> <a target="_blank" rel="nofollow noopener noreferrer">y</a>`,
+		},
+		{
+			"data and vbscript sources dropped",
+			`<img src="data:image/png;base64,AAAA"><img src="vbscript:x">`,
+			``,
+		},
+		{
+			"relative links resolved and opened safely",
+			`<a href="../about" class="x" style="color:red">About</a>`,
+			`<a href="https://example.com/about" target="_blank" rel="nofollow noopener noreferrer">About</a>`,
+		},
+		{
+			"mailto kept for links only",
+			`<a href="mailto:me@example.com">Mail</a>`,
+			`<a href="mailto:me@example.com" target="_blank" rel="nofollow noopener noreferrer">Mail</a>`,
+		},
+		{
+			"unknown elements unwrapped",
+			`<custom-tag><b>Bold</b></custom-tag>`,
+			`<b>Bold</b>`,
+		},
+		{
+			"1x1 pixels dropped",
+			`<p>Text<img src="https://example.com/p.gif" width="1" height="1"></p>`,
+			`<p>Text</p>`,
+		},
+		{
+			"tracker pixels dropped",
+			`<img src="https://pixel.wp.com/g.gif?x=1"><img src="https://feeds.feedburner.com/~r/Feed/~4/abc">`,
+			``,
+		},
+		{
+			"known embeds sandboxed",
+			`<iframe src="https://www.youtube.com/embed/abc" onload="x()"></iframe>`,
+			`<iframe src="https://www.youtube.com/embed/abc" sandbox="allow-scripts allow-same-origin allow-popups allow-presentation"></iframe>`,
+		},
+		{
+			"other iframes dropped",
+			`<iframe src="https://evil.example/"></iframe><iframe src="http://www.youtube.com/embed/abc"></iframe>`,
+			``,
+		},
+		{
+			"text escaped",
+			`<p>1 &lt; 2 &amp; "quoted"</p>`,
+			`<p>1 &lt; 2 &amp; &#34;quoted&#34;</p>`,
+		},
+	}
+	for _, tt := range tests {
+		if got := SanitizeHTML(tt.in, base); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeHTMLWithoutBase(t *testing.T) {
+	got := SanitizeHTML(`<a href="/relative">x</a><img src="/a.jpg">`, nil)
+	want := `<a target="_blank" rel="nofollow noopener noreferrer">x</a>`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`<p>One</p><p>Two</p>`, "One Two"},
+		{`<b>Bo</b>ld &amp; <i>italic</i>`, "Bold & italic"},
+		{"<p>Lots\n\n   of\tspace</p>", "Lots of space"},
+		{`<script>var x = 1;</script><style>p {}</style>Text`, "Text"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := PlainText(tt.in); got != tt.want {
+			t.Errorf("PlainText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// scheduledFeed is a feed paired with the time it next becomes due, and
+// its position in the queue
+type scheduledFeed struct {
+	feed  models.Feed
+	due   time.Time
+	index int
+}
+
+// feedQueue is a min-heap of feeds ordered by due time, indexed by feed ID
+// so a feed's entry can be replaced when it changes
+type feedQueue struct {
+	items []*scheduledFeed
+	byID  map[int64]*scheduledFeed
+}
+
+func newFeedQueue() *feedQueue {
+	return &feedQueue{byID: make(map[int64]*scheduledFeed)}
+}
+
+func (q *feedQueue) Len() int           { return len(q.items) }
+func (q *feedQueue) Less(i, j int) bool { return q.items[i].due.Before(q.items[j].due) }
+
+func (q *feedQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *feedQueue) Push(x interface{}) {
+	item := x.(*scheduledFeed)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+	q.byID[item.feed.ID] = item
+}
+
+func (q *feedQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items = q.items[:n-1]
+	delete(q.byID, item.feed.ID)
+	return item
+}
+
+// peek returns the feed that is due soonest without removing it
+func (q *feedQueue) peek() *scheduledFeed {
+	return q.items[0]
+}
+
+// set schedules a feed at due, replacing its previous entry
+func (q *feedQueue) set(feed models.Feed, due time.Time) {
+	if item, ok := q.byID[feed.ID]; ok {
+		item.feed, item.due = feed, due
+		heap.Fix(q, item.index)
+		return
+	}
+	heap.Push(q, &scheduledFeed{feed: feed, due: due})
+}
+
+// remove drops a feed from the queue, if it is queued
+func (q *feedQueue) remove(id int64) {
+	if item, ok := q.byID[id]; ok {
+		heap.Remove(q, item.index)
+	}
+}
+
+// popDue removes and returns every feed that is due at or before now
+func (q *feedQueue) popDue(now time.Time) []models.Feed {
+	var due []models.Feed
+	for q.Len() > 0 && !q.peek().due.After(now) {
+		due = append(due, heap.Pop(q).(*scheduledFeed).feed)
+	}
+	return due
+}
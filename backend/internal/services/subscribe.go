@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// CreateFeed subscribes a user to a feed. A feed that is already stored,
+// because another user follows it, is shared rather than fetched again.
+// New feeds are fetched and parsed before being stored, so broken URLs are
+// rejected up front. A website URL is replaced by the first feed it
+// advertises. The name, site URL and description default to the feed's
+// own metadata, and its current items are stored straight away. With
+// SkipValidation set the feed is stored exactly as given. It returns
+// database.ErrConflict if the user already subscribes to the feed.
+func (f *FeedFetcher) CreateFeed(userID int64, req models.CreateFeedRequest) (*models.Feed, error) {
+	if feed, err := f.subscribeExisting(userID, req.URL); feed != nil || err != nil {
+		return feed, err
+	}
+
+	if req.SkipValidation {
+		feed, err := f.db.CreateFeed(req)
+		if err != nil {
+			return nil, err
+		}
+		return feed, f.db.Subscribe(userID, feed.ID)
+	}
+
+	found, err := f.discover(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	parsed := found[0].Feed
+
+	// Discovery may have led from a website to a feed that is already stored
+	if feed, err := f.subscribeExisting(userID, found[0].URL); feed != nil || err != nil {
+		return feed, err
+	}
+
+	req.URL = found[0].URL
+	if req.Name == "" {
+		req.Name = strings.TrimSpace(parsed.Title)
+	}
+	if req.Name == "" {
+		if u, err := url.Parse(req.URL); err == nil {
+			req.Name = u.Host
+		}
+	}
+	if req.SiteURL == "" {
+		req.SiteURL = strings.TrimSpace(parsed.Link)
+	}
+	if req.Description == "" {
+		req.Description = strings.TrimSpace(parsed.Description)
+	}
+
+	feed, err := f.db.CreateFeed(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.db.Subscribe(userID, feed.ID); err != nil {
+		return nil, err
+	}
+
+	newPosts := len(f.storeItems(feed, parsed.Items))
+	now := time.Now()
+	if err := f.db.UpdateFeedLastFetched(feed.ID, now); err != nil {
+		log.Printf("Error updating feed last fetched time: %v", err)
+	} else {
+		feed.LastFetchedAt = &now
+	}
+
+	log.Printf("Created feed %s with %d posts", feed.Name, newPosts)
+	return feed, nil
+}
+
+// subscribeExisting subscribes a user to the stored feed with the given
+// URL. It returns a nil feed and error when no such feed is stored.
+func (f *FeedFetcher) subscribeExisting(userID int64, feedURL string) (*models.Feed, error) {
+	feed, err := f.db.GetFeedByURL(feedURL)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.db.Subscribe(userID, feed.ID); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
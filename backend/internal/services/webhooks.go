@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/database"
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+// Headers sent with every webhook call
+const (
+	WebhookEventHeader     = "X-Rssy-Event"
+	WebhookDeliveryHeader  = "X-Rssy-Delivery"
+	WebhookTimestampHeader = "X-Rssy-Timestamp"
+	WebhookSignatureHeader = "X-Rssy-Signature"
+)
+
+// ErrPrivateWebhookURL is returned by CheckURL for webhooks aimed at the
+// server's own network
+var ErrPrivateWebhookURL = errors.New("webhook URL is a local or private address")
+
+// webhookBatchSize is how many due deliveries are loaded at a time
+const webhookBatchSize = 100
+
+// WebhookOptions controls how the outbox is drained
+type WebhookOptions struct {
+	// Interval is how often the outbox is checked for due retries; new
+	// posts are sent straight away. A non-positive interval disables
+	// delivery.
+	Interval time.Duration
+	// Workers is the number of deliveries sent in parallel
+	Workers int
+	// Timeout bounds each call
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed
+	MaxAttempts int
+	// BackoffBase is the delay before the first retry; it doubles for
+	// every further failure
+	BackoffBase time.Duration
+	// BackoffMax caps the retry delay
+	BackoffMax time.Duration
+	// AllowPrivate lets webhooks be sent to the server's own network, such
+	// as a home automation hub on the LAN. CheckURL and the dialer both
+	// refuse such receivers otherwise.
+	AllowPrivate bool
+}
+
+// WebhookDispatcher sends the deliveries queued in the webhook outbox,
+// retrying failures with exponential backoff. Deliveries are persisted, so
+// any still pending at shutdown are sent after the next start.
+type WebhookDispatcher struct {
+	db     *database.DB
+	events *EventHub
+	client *http.Client
+	opts   WebhookOptions
+	queue  *workQueue[models.PendingDelivery]
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewWebhookDispatcher creates a dispatcher that is woken by the
+// posts.created events on the hub
+func NewWebhookDispatcher(db *database.DB, events *EventHub, opts WebhookOptions) *WebhookDispatcher {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &WebhookDispatcher{
+		db:     db,
+		events: events,
+		client: &http.Client{Timeout: opts.Timeout, Transport: newPublicTransport(opts.AllowPrivate)},
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	d.queue = &workQueue[models.PendingDelivery]{
+		name:      "Webhook dispatcher",
+		jobs:      "webhook deliveries",
+		interval:  opts.Interval,
+		workers:   opts.Workers,
+		batchSize: webhookBatchSize,
+		events:    events,
+		load:      db.GetDueDeliveries,
+		run:       d.deliver,
+		ctx:       ctx,
+	}
+	return d
+}
+
+// CheckURL reports whether a webhook may be sent to raw: it must be an
+// absolute http(s) URL and, unless private addresses are allowed, must not
+// name the local machine or a private IP address
+func (d *WebhookDispatcher) CheckURL(raw string) error {
+	if !IsHTTPURL(raw) {
+		return ErrInvalidURL
+	}
+	if u, _ := url.Parse(raw); !d.opts.AllowPrivate && isPrivateHost(u.Hostname()) {
+		return ErrPrivateWebhookURL
+	}
+	return nil
+}
+
+// Start begins draining the outbox
+func (d *WebhookDispatcher) Start() {
+	if d.opts.Interval <= 0 {
+		log.Println("Webhook dispatcher disabled")
+		return
+	}
+
+	log.Printf("Starting webhook dispatcher with interval: %v (max %d attempts)",
+		d.opts.Interval, d.opts.MaxAttempts)
+	d.queue.start()
+}
+
+// Stop stops the dispatcher, abandoning calls in flight; they are retried
+// after the next start
+func (d *WebhookDispatcher) Stop() {
+	log.Println("Stopping webhook dispatcher...")
+	d.cancel()
+}
+
+// deliver makes one attempt at a delivery and records the outcome
+func (d *WebhookDispatcher) deliver(delivery models.PendingDelivery) {
+	statusCode, err := d.send(delivery)
+	if d.ctx.Err() != nil {
+		// Interrupted by shutdown; not the receiver's fault
+		return
+	}
+
+	if err == nil {
+		if err := d.db.RecordDeliverySuccess(delivery.ID, statusCode); err != nil {
+			log.Printf("Error recording webhook delivery: %v", err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if attempts := delivery.Attempts + 1; attempts < d.opts.MaxAttempts {
+		at := time.Now().Add(backoffDelay(d.opts.BackoffBase, d.opts.BackoffMax, attempts))
+		retryAt = &at
+	} else {
+		log.Printf("Webhook delivery %d failed after %d attempts: %v", delivery.ID, attempts, err)
+	}
+
+	if err := d.db.RecordDeliveryFailure(delivery.ID, statusCode, err.Error(), retryAt); err != nil {
+		log.Printf("Error recording webhook delivery: %v", err)
+	}
+}
+
+// send POSTs a delivery's payload, returning the response status code (0
+// without a response) and an error unless the receiver answered 2xx
+func (d *WebhookDispatcher) send(delivery models.PendingDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook computes the signature header value for a payload:
+// "sha256=" followed by the hex HMAC-SHA256, keyed with the webhook secret,
+// of the timestamp, a ".", and the body. Receivers should recompute it and
+// reject stale timestamps to prevent replays.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateWebhookSecret creates a random secret for signing webhook calls
+func GenerateWebhookSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
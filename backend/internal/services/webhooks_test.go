@@ -0,0 +1,117 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/justanotherspy/rssy/internal/models"
+)
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"post.created"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := SignWebhook("secret", 1700000000, body); got != want {
+		t.Errorf("SignWebhook = %q, want %q", got, want)
+	}
+	if got := SignWebhook("other", 1700000000, body); got == want {
+		t.Error("SignWebhook gave the same signature for a different secret")
+	}
+	if got := SignWebhook("secret", 1700000001, body); got == want {
+		t.Error("SignWebhook gave the same signature for a different timestamp")
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	payload := []byte(`{"event":"post.created","post":{"id":7}}`)
+
+	var verified bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if err != nil {
+			t.Errorf("bad timestamp header: %v", err)
+		}
+		if got := r.Header.Get(WebhookEventHeader); got != "post.created" {
+			t.Errorf("event header = %q", got)
+		}
+		if got := r.Header.Get(WebhookDeliveryHeader); got != "42" {
+			t.Errorf("delivery header = %q", got)
+		}
+		verified = r.Header.Get(WebhookSignatureHeader) == SignWebhook("s3cret", timestamp, body)
+
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	d := NewWebhookDispatcher(nil, nil, WebhookOptions{Timeout: 5 * time.Second, AllowPrivate: true})
+	delivery := models.PendingDelivery{ID: 42, URL: receiver.URL + "/ok", Event: "post.created", Secret: "s3cret", Payload: payload}
+
+	status, err := d.send(delivery)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("send = %d, %v; want 200, nil", status, err)
+	}
+	if !verified {
+		t.Error("receiver could not verify the signature")
+	}
+
+	delivery.URL = receiver.URL + "/fail"
+	status, err = d.send(delivery)
+	if err == nil || status != http.StatusServiceUnavailable {
+		t.Errorf("send to failing receiver = %d, %v; want 503 and an error", status, err)
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("receiver on a private address was called")
+	}))
+	defer receiver.Close()
+
+	d := NewWebhookDispatcher(nil, nil, WebhookOptions{Timeout: 5 * time.Second})
+	_, err := d.send(models.PendingDelivery{ID: 1, URL: receiver.URL, Event: "post.created", Payload: []byte("{}")})
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("send = %v, want %v", err, errPrivateAddress)
+	}
+}
+
+func TestWebhookCheckURL(t *testing.T) {
+	public := NewWebhookDispatcher(nil, nil, WebhookOptions{})
+	private := NewWebhookDispatcher(nil, nil, WebhookOptions{AllowPrivate: true})
+
+	tests := []struct {
+		url           string
+		want          error
+		wantIfPrivate error
+	}{
+		{"https://example.com/hook", nil, nil},
+		{"ftp://example.com/hook", ErrInvalidURL, ErrInvalidURL},
+		{"/hook", ErrInvalidURL, ErrInvalidURL},
+		{"http://localhost:8080/hook", ErrPrivateWebhookURL, nil},
+		{"http://api.localhost/hook", ErrPrivateWebhookURL, nil},
+		{"http://127.0.0.1/hook", ErrPrivateWebhookURL, nil},
+		{"http://10.1.2.3/hook", ErrPrivateWebhookURL, nil},
+		{"http://169.254.169.254/latest", ErrPrivateWebhookURL, nil},
+		{"http://[::1]/hook", ErrPrivateWebhookURL, nil},
+	}
+	for _, tt := range tests {
+		if got := public.CheckURL(tt.url); !errors.Is(got, tt.want) {
+			t.Errorf("CheckURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+		if got := private.CheckURL(tt.url); !errors.Is(got, tt.wantIfPrivate) {
+			t.Errorf("CheckURL(%q) with private addresses allowed = %v, want %v", tt.url, got, tt.wantIfPrivate)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// workQueue drains a persisted queue of jobs, such as webhook deliveries or
+// content extractions. Due jobs are run on a pool of workers at start, on
+// every tick and whenever new posts are stored; jobs still queued at
+// shutdown are left for the next start.
+type workQueue[T any] struct {
+	// name is how the queue's owner is called in log messages
+	name string
+	// jobs describes the queued jobs in log messages
+	jobs      string
+	interval  time.Duration
+	workers   int
+	batchSize int
+	events    *EventHub
+	// load returns up to limit jobs that are due
+	load func(limit int) ([]T, error)
+	// run makes one attempt at a job and records its outcome
+	run  func(job T)
+	ctx  context.Context
+	wake chan struct{}
+}
+
+// start runs due jobs until ctx is cancelled
+func (q *workQueue[T]) start() {
+	q.wake = make(chan struct{}, 1)
+
+	go q.watchEvents()
+
+	go func() {
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+
+		q.runDue()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-q.wake:
+			case <-q.ctx.Done():
+				log.Printf("%s stopped", q.name)
+				return
+			}
+			q.runDue()
+		}
+	}()
+}
+
+// watchEvents wakes the queue whenever new posts are stored, so the jobs
+// they add run without waiting for the next tick
+func (q *workQueue[T]) watchEvents() {
+	for {
+		sub := q.events.Subscribe(0)
+		func() {
+			defer sub.Close()
+			for {
+				select {
+				case event, ok := <-sub.Events:
+					if !ok {
+						return
+					}
+					if event.Type == EventPostsCreated {
+						select {
+						case q.wake <- struct{}{}:
+						default:
+						}
+					}
+				case <-q.ctx.Done():
+					return
+				}
+			}
+		}()
+
+		// Dropped as too slow, or the hub closed; the ticker covers the gap
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-time.After(q.interval):
+		}
+	}
+}
+
+// runDue runs every job that is due, a batch at a time
+func (q *workQueue[T]) runDue() {
+	for q.ctx.Err() == nil {
+		due, err := q.load(q.batchSize)
+		if err != nil {
+			log.Printf("Error loading %s: %v", q.jobs, err)
+			return
+		}
+
+		slots := make(chan struct{}, q.workers)
+		var wg sync.WaitGroup
+		for _, job := range due {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				q.run(job)
+			}()
+		}
+		wg.Wait()
+
+		if len(due) < q.batchSize {
+			return
+		}
+	}
+}
+
+// backoffDelay returns the delay before retrying after the given number of
+// consecutive failures: base, doubled for every failure after the first
+// and capped at max unless max is zero
+func backoffDelay(base, max time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if max > 0 && delay >= max {
+			return max
+		}
+	}
+	return delay
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		max      time.Duration
+		want     time.Duration
+	}{
+		{1, 10 * time.Minute, time.Minute},
+		{2, 10 * time.Minute, 2 * time.Minute},
+		{3, 10 * time.Minute, 4 * time.Minute},
+		{4, 10 * time.Minute, 8 * time.Minute},
+		{5, 10 * time.Minute, 10 * time.Minute},
+		{20, 10 * time.Minute, 10 * time.Minute},
+		{5, 0, 16 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoffDelay(time.Minute, tt.max, tt.failures); got != tt.want {
+			t.Errorf("backoffDelay(1m, %v, %d) = %v, want %v", tt.max, tt.failures, got, tt.want)
+		}
+	}
+}
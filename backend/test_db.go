@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -21,7 +22,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	fmt.Println("=== Testing Feed CRUD Operations ===\n")
+	user, err := db.CreateUser("test", "not-a-real-hash", true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("=== Testing Feed CRUD Operations ===\n\n")
 
 	// Test 1: Create a new feed
 	fmt.Println("1. Creating a new feed...")
@@ -36,11 +42,14 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := db.Subscribe(user.ID, feed.ID); err != nil {
+		log.Fatal(err)
+	}
 	fmt.Printf("   ✓ Created feed ID: %d, Name: %s\n\n", feed.ID, feed.Name)
 
 	// Test 2: Get all feeds
 	fmt.Println("2. Retrieving all feeds...")
-	feeds, err := db.GetAllFeeds()
+	feeds, err := db.GetUserFeeds(user.ID)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -81,7 +90,7 @@ func main() {
 	updatedFeed, _ = db.GetFeedByID(feed.ID)
 	fmt.Printf("   ✓ Last fetched: %v\n\n", updatedFeed.LastFetchedAt)
 
-	fmt.Println("=== Testing Post CRUD Operations ===\n")
+	fmt.Printf("=== Testing Post CRUD Operations ===\n\n")
 
 	// Test 6: Create posts
 	fmt.Println("6. Creating test posts...")
@@ -116,7 +125,7 @@ func main() {
 
 	// Test 7: Get all posts
 	fmt.Println("7. Retrieving all posts...")
-	posts, err := db.GetAllPosts(10, 0)
+	posts, err := db.GetAllPosts(user.ID, 10, 0)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -128,7 +137,7 @@ func main() {
 
 	// Test 8: Get posts by feed ID
 	fmt.Println("8. Getting posts by feed ID...")
-	feedPosts, err := db.GetPostsByFeedID(feed.ID, 10, 0)
+	feedPosts, err := db.GetPostsByFeedID(user.ID, feed.ID, 10, 0)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -144,12 +153,12 @@ func main() {
 
 	// Test 10: Mark post as read
 	fmt.Println("10. Marking post as read...")
-	if err := db.MarkPostAsRead(post1.ID, true); err != nil {
+	if err := db.MarkPostAsRead(user.ID, post1.ID, true); err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("   ✓ Post marked as read\n")
+	fmt.Printf("   ✓ Post marked as read\n\n")
 
-	fmt.Println("=== Testing Constraints ===\n")
+	fmt.Printf("=== Testing Constraints ===\n\n")
 
 	// Test 11: Try to create duplicate post (should fail)
 	fmt.Println("11. Testing duplicate GUID constraint...")
@@ -164,29 +173,40 @@ func main() {
 	if err != nil {
 		fmt.Printf("   ✓ Duplicate correctly rejected: %v\n\n", err)
 	} else {
-		fmt.Println("   ✗ ERROR: Duplicate post was allowed!\n")
+		fmt.Printf("   ✗ ERROR: Duplicate post was allowed!\n\n")
 	}
 
-	// Test 12: Delete feed (should cascade to posts)
-	fmt.Println("12. Testing cascade delete...")
-	postsBeforeDelete, _ := db.GetPostsByFeedID(feed.ID, 100, 0)
-	fmt.Printf("   Posts before delete: %d\n", len(postsBeforeDelete))
+	// Test 12: Unsubscribe the only subscriber (should delete the feed and
+	// cascade to its posts)
+	fmt.Println("12. Testing unsubscribe and cascade delete...")
+	var postsBeforeDelete int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts WHERE feed_id = ?", feed.ID).Scan(&postsBeforeDelete); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("   Posts before unsubscribing: %d\n", postsBeforeDelete)
 
-	if err := db.DeleteFeed(feed.ID); err != nil {
+	if err := db.Unsubscribe(user.ID, feed.ID); err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("   ✓ Feed deleted")
+	fmt.Println("   ✓ Unsubscribed")
 
-	postsAfterDelete, _ := db.GetPostsByFeedID(feed.ID, 100, 0)
-	fmt.Printf("   Posts after delete: %d\n", len(postsAfterDelete))
-	fmt.Println("   ✓ Cascade delete working correctly\n")
+	var postsAfterDelete int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts WHERE feed_id = ?", feed.ID).Scan(&postsAfterDelete); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("   Posts after unsubscribing: %d\n", postsAfterDelete)
+	if _, err := db.GetFeedByID(feed.ID); errors.Is(err, database.ErrNotFound) && postsAfterDelete == 0 {
+		fmt.Printf("   ✓ Unsubscribed feed and its posts deleted\n\n")
+	} else {
+		fmt.Printf("   ✗ ERROR: Feed or posts were kept after its last subscriber left!\n\n")
+	}
 
 	// Test 13: Delete all posts
 	fmt.Println("13. Testing delete all posts...")
 	if err := db.DeleteAllPosts(); err != nil {
 		log.Fatal(err)
 	}
-	allPostsAfter, _ := db.GetAllPosts(100, 0)
+	allPostsAfter, _ := db.GetAllPosts(user.ID, 100, 0)
 	fmt.Printf("   ✓ All posts deleted (remaining: %d)\n\n", len(allPostsAfter))
 
 	fmt.Println("=== All Tests Passed! ===")
@@ -2,6 +2,8 @@ import axios from 'axios';
 
 const API_BASE_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
 
+const TOKEN_KEY = 'rssy_token';
+
 const api = axios.create({
   baseURL: API_BASE_URL,
   headers: {
@@ -9,6 +11,60 @@ const api = axios.create({
   },
 });
 
+// Session token, kept in localStorage so a reload stays logged in
+export function getToken(): string | null {
+  return typeof localStorage === 'undefined' ? null : localStorage.getItem(TOKEN_KEY);
+}
+
+export function setToken(token: string | null) {
+  if (typeof localStorage === 'undefined') return;
+  if (token) {
+    localStorage.setItem(TOKEN_KEY, token);
+  } else {
+    localStorage.removeItem(TOKEN_KEY);
+  }
+}
+
+let unauthorizedHandler: (() => void) | null = null;
+
+// Called when the API rejects the token, e.g. once the session expires
+export function onUnauthorized(handler: () => void) {
+  unauthorizedHandler = handler;
+}
+
+api.interceptors.request.use((config) => {
+  const token = getToken();
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
+api.interceptors.response.use(
+  (response) => response,
+  (error) => {
+    if (error.response?.status === 401 && !error.config?.url?.endsWith('/api/auth/login')) {
+      setToken(null);
+      unauthorizedHandler?.();
+    }
+    return Promise.reject(error);
+  }
+);
+
+export interface User {
+  id: number;
+  username: string;
+  is_admin: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface LoginResponse {
+  token: string;
+  expires_at: string | null;
+  user: User;
+}
+
 export interface Feed {
   id: number;
   name: string;
@@ -58,6 +114,14 @@ export interface UpdateFeedRequest {
   is_active?: boolean;
 }
 
+// Auth API
+export const authApi = {
+  login: (username: string, password: string) =>
+    api.post<{ data: LoginResponse }>('/api/auth/login', { username, password }),
+  logout: () => api.post('/api/auth/logout'),
+  me: () => api.get<{ data: User }>('/api/auth/me'),
+};
+
 // Feed API
 export const feedsApi = {
   getAll: () => api.get<{ data: Feed[] }>('/api/feeds'),
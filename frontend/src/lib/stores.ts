@@ -1,6 +1,7 @@
 import { writable } from 'svelte/store';
-import type { Feed, Post } from './api';
+import type { Feed, Post, User } from './api';
 
+export const currentUser = writable<User | null>(null);
 export const feeds = writable<Feed[]>([]);
 export const posts = writable<Post[]>([]);
 export const selectedFeedId = writable<number | null>(null);